@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	bytes   int64
+	written bool
+	capture *bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.written {
+		rec.status = status
+		rec.written = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.written = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	if rec.capture != nil {
+		rec.capture.Write(b[:n])
+	}
+	return n, err
+}
+
+func (rec *responseRecorder) Flush() {
+	rec.written = true
+	_ = http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// LogSampling reduces access log volume. A request is logged when any of the
+// configured conditions holds; the zero value logs every request. Responses
+// with a 5xx status are always logged.
+type LogSampling struct {
+	Every      int
+	SlowerThan time.Duration
+	Non2xx     bool
+}
+
+func (s *LogSampling) sample(count uint64, status int, elapsed time.Duration) bool {
+	if status >= 500 || *s == (LogSampling{}) {
+		return true
+	}
+	if s.Every > 0 && count%uint64(s.Every) == 0 {
+		return true
+	}
+	if s.SlowerThan > 0 && elapsed >= s.SlowerThan {
+		return true
+	}
+	return s.Non2xx && (status < 200 || status > 299)
+}
+
+func WithLogSampling(s LogSampling) HandlerOption {
+	return func(a *apiFunc) {
+		a.sampling = &s
+	}
+}
+
+// WithLogFields adds the fields returned by fields to the handler's access log
+// entries. Only what fields returns is logged, so sensitive values are left
+// out simply by not returning them.
+func WithLogFields(fields func(r *http.Request, status int) map[string]interface{}) HandlerOption {
+	return func(a *apiFunc) {
+		a.logFields = fields
+	}
+}
+
+func (m *Manager) logAccess(r *http.Request, af *apiFunc, rec *responseRecorder, elapsed time.Duration, reqBytes int64) {
+	if !m.AccessLog {
+		return
+	}
+	sampling := af.sampling
+	if sampling == nil {
+		sampling = &m.LogSampling
+	}
+	if !sampling.sample(atomic.AddUint64(&af.requests, 1)-1, rec.status, elapsed) {
+		return
+	}
+	args := []interface{}{
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", rec.status,
+		"request_bytes", reqBytes,
+		"content_length", r.ContentLength,
+		"bytes", rec.bytes,
+		"duration", elapsed,
+	}
+	if rec.Header().Get("Idempotent-Replayed") == "true" {
+		args = append(args, "replayed", true)
+	}
+	if af.logFields != nil {
+		extra := af.logFields(r, rec.status)
+		keys := make([]string, 0, len(extra))
+		for k := range extra {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			args = append(args, k, extra[k])
+		}
+	}
+	LoggerFromContext(r.Context()).Info("request", args...)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// AccountIDResolver identifies the account a request acts for. Errors are
+// sent to the client, so they should usually be an *Error with status 401.
+type AccountIDResolver interface {
+	AccountID(r *http.Request) (int, error)
+}
+
+// AnonymousAccountID is resolved for callers that authenticated without
+// acting for an account, such as anonymous service tokens. Handlers that
+// take an account ID, or use WithAuthorization, get 401 for it unless
+// registered WithAnonymousAccount.
+const AnonymousAccountID = 0
+
+var errAnonymousAccount = &Error{
+	Status:  http.StatusUnauthorized,
+	Code:    "account_required",
+	Message: "this endpoint requires an account",
+}
+
+// WithAnonymousAccount passes AnonymousAccountID through to the handler,
+// which must then treat it as a caller without an account.
+func WithAnonymousAccount() HandlerOption {
+	return func(a *apiFunc) {
+		a.allowAnonymous = true
+	}
+}
+
+// needsAccount reports whether the handler sees the account ID, directly or
+// through its authorization check.
+func (af *apiFunc) needsAccount() bool {
+	return af.hasAccountID || af.authorize != nil
+}
+
+func (af *apiFunc) rejectsAnonymous(accountID int) bool {
+	return accountID == AnonymousAccountID && !af.allowAnonymous && af.needsAccount()
+}
+
+type AccountIDResolverFunc func(r *http.Request) (int, error)
+
+func (f AccountIDResolverFunc) AccountID(r *http.Request) (int, error) {
+	return f(r)
+}
+
+// OnBehalfOf lets authenticated internal services act for a user by naming
+// the account in a header, X-On-Behalf-Of by default. Requests without the
+// header are resolved by Resolver. The header is only honoured when
+// TrustedCaller, which must check the caller's identity by other means such
+// as mTLS or a service token, reports true; anyone else sending it gets 403
+// rather than having it silently ignored. A nil TrustedCaller trusts no one.
+type OnBehalfOf struct {
+	Resolver      AccountIDResolver
+	Header        string
+	TrustedCaller func(r *http.Request) bool
+}
+
+func (o *OnBehalfOf) header() string {
+	if o.Header == "" {
+		return "X-On-Behalf-Of"
+	}
+	return o.Header
+}
+
+func (o *OnBehalfOf) AccountID(r *http.Request) (int, error) {
+	header := o.header()
+	values := r.Header.Values(header)
+	if len(values) == 0 {
+		return o.Resolver.AccountID(r)
+	}
+	if o.TrustedCaller == nil || !o.TrustedCaller(r) {
+		return 0, &Error{Status: http.StatusForbidden, Message: header + " is not allowed for this caller"}
+	}
+	if len(values) > 1 {
+		return 0, &Error{Status: http.StatusBadRequest, Message: "multiple " + header + " headers"}
+	}
+	id, err := strconv.Atoi(values[0])
+	if err != nil || id <= 0 {
+		return 0, &Error{Status: http.StatusBadRequest, Message: "invalid " + header + " header"}
+	}
+	return id, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CachedAccountIDResolver remembers successful resolutions by Resolver for
+// TTL, keyed by a hash of the request's credentials, the Authorization
+// header unless Key says otherwise. Delegated requests, carrying
+// X-On-Behalf-Of or the header of an OnBehalfOf Resolver, always go to
+// Resolver so the caller is checked every time. Concurrent misses for the
+// same credentials share one call to Resolver. Failures are never cached,
+// and a cached entry is dropped when Resolver fails for it or a response
+// for it has status 401.
+type CachedAccountIDResolver struct {
+	Resolver AccountIDResolver
+	TTL      time.Duration
+	Key      func(r *http.Request) string
+
+	mu       sync.Mutex
+	entries  map[[sha256.Size]byte]cachedAccount
+	inFlight map[[sha256.Size]byte]*accountCall
+}
+
+type cachedAccount struct {
+	id      int
+	expires time.Time
+}
+
+type accountCall struct {
+	done chan struct{}
+	id   int
+	err  error
+}
+
+func NewCachedAccountIDResolver(resolver AccountIDResolver, ttl time.Duration) *CachedAccountIDResolver {
+	return &CachedAccountIDResolver{Resolver: resolver, TTL: ttl}
+}
+
+func (c *CachedAccountIDResolver) key(r *http.Request) ([sha256.Size]byte, bool) {
+	var cred string
+	if c.Key != nil {
+		cred = c.Key(r)
+	} else {
+		cred = r.Header.Get("Authorization")
+	}
+	if cred == "" {
+		return [sha256.Size]byte{}, false
+	}
+	return sha256.Sum256([]byte(cred)), true
+}
+
+// delegated reports whether r asks to act for another account, which only
+// Resolver can allow for this particular caller.
+func (c *CachedAccountIDResolver) delegated(r *http.Request) bool {
+	header := "X-On-Behalf-Of"
+	if o, ok := c.Resolver.(*OnBehalfOf); ok {
+		header = o.header()
+	}
+	return len(r.Header.Values(header)) > 0
+}
+
+func (c *CachedAccountIDResolver) AccountID(r *http.Request) (int, error) {
+	if c.delegated(r) {
+		return c.Resolver.AccountID(r)
+	}
+	key, ok := c.key(r)
+	if !ok {
+		return c.Resolver.AccountID(r)
+	}
+	now := time.Now()
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && now.Before(e.expires) {
+		c.mu.Unlock()
+		return e.id, nil
+	}
+	if call, ok := c.inFlight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.id, call.err
+	}
+	call := &accountCall{done: make(chan struct{})}
+	if c.inFlight == nil {
+		c.inFlight = map[[sha256.Size]byte]*accountCall{}
+		c.entries = map[[sha256.Size]byte]cachedAccount{}
+	}
+	c.inFlight[key] = call
+	c.mu.Unlock()
+
+	// waiters see a failure if Resolver panics
+	call.err = &Error{Status: http.StatusUnauthorized, Message: "account could not be resolved"}
+	defer func() {
+		c.mu.Lock()
+		delete(c.inFlight, key)
+		if call.err != nil {
+			delete(c.entries, key)
+		} else {
+			c.sweep(now)
+			c.entries[key] = cachedAccount{id: call.id, expires: now.Add(c.TTL)}
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	id, err := c.Resolver.AccountID(r)
+	call.id, call.err = id, err
+	return id, err
+}
+
+// sweep drops expired entries once the cache has grown.
+func (c *CachedAccountIDResolver) sweep(now time.Time) {
+	if len(c.entries) < 1024 {
+		return
+	}
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Invalidate forgets the cached resolution for r's credentials.
+func (c *CachedAccountIDResolver) Invalidate(r *http.Request) {
+	key, ok := c.key(r)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
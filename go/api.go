@@ -1,13 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"mime"
 	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
@@ -29,20 +35,238 @@ func unwrap(err interface{}) error {
 	return err.(error)
 }
 
+// ResponseWritten is returned by handlers that wrote the response themselves
+// through ResponseWriterFromContext, so the harness writes nothing further.
+var ResponseWritten = errors.New("response already written")
+
+type responseWriterKey struct{}
+
+func ResponseWriterFromContext(ctx context.Context) http.ResponseWriter {
+	w, _ := ctx.Value(responseWriterKey{}).(http.ResponseWriter)
+	return w
+}
+
 type Error struct {
 	Status  int
+	Code    string
 	Message string
+
+	// Retryable marks transient failures. RetryAfter, when set, is sent as
+	// the Retry-After header.
+	Retryable  bool
+	RetryAfter time.Duration
 }
 
 func (e *Error) Error() string {
 	return e.Message
 }
 
+type Encoder interface {
+	Encode(v interface{}) error
+	SetIndent(prefix, indent string)
+}
+
+type Decoder interface {
+	Decode(v interface{}) error
+	DisallowUnknownFields()
+	UseNumber()
+	More() bool
+	Token() (json.Token, error)
+}
+
+// Codec abstracts JSON marshaling so faster implementations can be swapped in.
+type Codec interface {
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+type stdCodec struct{}
+
+func (stdCodec) NewEncoder(w io.Writer) Encoder {
+	return json.NewEncoder(w)
+}
+
+func (stdCodec) NewDecoder(r io.Reader) Decoder {
+	return json.NewDecoder(r)
+}
+
 type Manager struct {
+	Codec  Codec
+	Indent string
+
+	// DisablePretty always emits compact JSON, overriding Indent, for
+	// environments where byte-exact output matters more than readability.
+	DisablePretty bool
+
+	// Canonical makes responses byte-for-byte deterministic for a given
+	// value, for stable ETags and golden files, at the cost of an extra
+	// decode and encode per response.
+	Canonical bool
+
+	// BaseContext returns the context handlers run under. It defaults to
+	// the request's own context.
+	BaseContext func(r *http.Request) context.Context
+
+	// RequestIDHeaders are checked in order for an incoming request ID; the
+	// first is also used to return it. NewRequestID generates missing IDs.
+	RequestIDHeaders []string
+	NewRequestID     func() string
+
+	// MaxConcurrent caps the requests handled at once across all handlers;
+	// the excess get 503 with ConcurrencyRetryAfter, one second by default.
+	// ConcurrencyExempt lists paths, such as health checks, that bypass it.
+	MaxConcurrent         int
+	ConcurrencyRetryAfter time.Duration
+	ConcurrencyExempt     []string
+
+	// MaintenanceBypassHeader carries the token that lets a request through
+	// maintenance mode, X-Maintenance-Bypass by default. See SetMaintenance.
+	MaintenanceBypassHeader string
+
+	// TraceSampleRate is the fraction of requests, from 0 to 1, logged with
+	// a breakdown of the time spent decoding, in the handler and encoding.
+	TraceSampleRate float64
+
+	// RetryAttemptHeader is read for the attempt number clients send with
+	// retries, logged alongside any Idempotency-Key. It defaults to
+	// X-Retry-Attempt.
+	RetryAttemptHeader string
+
+	// OnRateLimited writes the response for requests rejected by a
+	// WithRateLimit limiter, after the RateLimit-* headers are set.
+	OnRateLimited func(w http.ResponseWriter, r *http.Request, rl RateLimit)
+
+	// LogDeprecated logs each call to a handler marked WithDeprecation.
+	LogDeprecated bool
+
+	// BindPrecedence decides between a value bound from the query, path,
+	// headers or cookies and the same field set in the body, for handlers
+	// without their own WithBindPrecedence. The zero value prefers the
+	// bound value.
+	BindPrecedence BindPrecedence
+
+	// DeleteResponse shapes successful DELETE responses for handlers without
+	// their own WithDeleteResponse. The zero value echoes the output.
+	DeleteResponse DeleteResponse
+
+	// ErrorMetadata adds debugging fields to every error body.
+	ErrorMetadata ErrorMetadata
+
+	// AccountIDResolver supplies the account ID passed to handlers.
+	AccountIDResolver AccountIDResolver
+
+	// RedirectTrailingSlash redirects a path that matches no route to its
+	// form with the trailing slash added or removed, if that one matches.
+	RedirectTrailingSlash bool
+
+	// AutoOptions answers OPTIONS requests on routed paths with the
+	// registered methods in an Allow header.
+	AutoOptions bool
+
+	// EmptyBody is written with EmptyStatus and EmptyContentType by
+	// handlers that have no output value.
+	EmptyBody        []byte
+	EmptyStatus      int
+	EmptyContentType string
+
+	// MaxDepth limits how deeply request bodies may nest objects and arrays.
+	MaxDepth int
+
+	// MaxBodyBytes caps the size of request bodies; larger ones get 413.
+	MaxBodyBytes int64
+
+	UseNumber bool
+
+	// NilSliceAsNull encodes a nil slice response as null rather than the
+	// default []. Nested slices are encoded as encoding/json does either way.
+	NilSliceAsNull bool
+
+	// JSONLinesFlushEvery is how many lines of a JSON Lines response are
+	// written between flushes.
+	JSONLinesFlushEvery int
+
+	// RejectNull turns on WithRejectNull for every handler.
+	RejectNull bool
+
+	// ValidationStatus is sent when an input fails Validate or its schema.
+	ValidationStatus int
+
+	// AccessLog logs each completed request, thinned out by LogSampling
+	// unless a handler overrides it with WithLogSampling.
+	AccessLog   bool
+	LogSampling LogSampling
+
+	Preload bool
+
+	// EnableEcho turns on EchoHandler. Keep it off in production or mount
+	// the handler behind authentication.
+	EnableEcho bool
+
+	// Metrics, when set, receives per-route measurements. Requests and
+	// responses larger than the soft limits are logged as warnings.
+	Metrics           Metrics
+	SoftRequestBytes  int64
+	SoftResponseBytes int64
+
+	// BodySizeDeviation, when set, logs a warning for request bodies more
+	// than that many standard deviations from the handler's typical size.
+	BodySizeDeviation float64
+
+	// StrictOutputs logs a warning when a handler returns a non-zero output
+	// together with a non-nil error. The error is sent either way.
+	StrictOutputs bool
+
+	// Localize looks up the message for an error code in a language from
+	// the request's Accept-Language. Errors fall back to their Message.
+	Localize func(code, lang string) (string, bool)
+
+	// SnapshotFlags are read from FlagSource once per request and exposed
+	// through FlagsFromContext.
+	FlagSource    FlagSource
+	SnapshotFlags []string
+
+	// OnPanic is called with the value of a recovered handler panic, after
+	// it is logged, for alerting or metrics. Its own panics are contained.
+	OnPanic func(recovered interface{}, r *http.Request)
+
+	// DefaultView is passed to Representer outputs when the request has no
+	// ?view= parameter.
+	DefaultView string
+
+	log         *slog.Logger
+	mux         *http.ServeMux
+	routes      map[string]*route
+	middleware  []func(http.Handler) http.Handler
+	conditional []conditionalMiddleware
+	handler     http.Handler
+	encoders    []registeredEncoder
+	decoders    []registeredDecoder
+	inFlight    int64
+
+	maintenance maintenance
 }
 
-func NewManager() *Manager {
-	return &Manager{}
+// NewManager returns a Manager logging to log. A nil log discards output.
+func NewManager(log *slog.Logger) *Manager {
+	if log == nil {
+		log = slog.New(slog.DiscardHandler)
+	}
+	return &Manager{
+		log:                 log,
+		Codec:               stdCodec{},
+		RequestIDHeaders:    []string{"X-Request-ID"},
+		NewRequestID:        UUIDRequestID,
+		AutoOptions:         true,
+		EmptyBody:           emptyJSON,
+		EmptyStatus:         http.StatusOK,
+		EmptyContentType:    jsonCT,
+		MaxDepth:            1000,
+		JSONLinesFlushEvery: 100,
+		ValidationStatus:    http.StatusUnprocessableEntity,
+		mux:                 http.NewServeMux(),
+		routes:              map[string]*route{},
+		encoders:            []registeredEncoder{{mediaType: "text/csv", encode: EncodeCSV}},
+	}
 }
 
 type apiFunc struct {
@@ -54,7 +278,62 @@ type apiFunc struct {
 	hasInput       bool
 	hasOutput      bool
 	hasOutputError bool
+	hasStream      bool
+	hasInputPtr    bool
 	inputType      reflect.Type
+	elemType       reflect.Type
+	schema         Schema
+	bindings       []fieldBinding
+	sampling       *LogSampling
+	checkOutput    bool
+	breaker        *CircuitBreaker
+	ignoreBody     bool
+	idempotency    *idempotency
+	textResponse   bool
+	useNumber      bool
+	signature      *Signature
+	dryRun         DryRunMode
+	contentTypes   []string
+	coalescer      *coalescer
+	validates      bool
+	template       *responseTemplate
+	authorize      func(ctx context.Context, accountID int) error
+	fieldSelection bool
+	logFields      func(r *http.Request, status int) map[string]interface{}
+	timeFields     timeFields
+	sizes          sizeStats
+	responseLog    bool
+	pooled         bool
+	interceptors   []inputInterceptor
+	rejectNull     bool
+	rateLimiter    RateLimiter
+	sized          bool
+	transform      func([]byte) ([]byte, error)
+	deprecation    *deprecation
+	normalize      bool
+	deleteResponse DeleteResponse
+	bindPrecedence BindPrecedence
+	readTimeout    time.Duration
+	processTimeout time.Duration
+	allowAnonymous bool
+	bodyBound      bool
+	bodyDefaults   bool
+	tags           []string
+	requests       uint64
+}
+
+type HandlerOption func(*apiFunc)
+
+// streamElem reports the element type T when t is func(func(T) error) error.
+func streamElem(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 1 || t.Out(0) != errorType {
+		return nil, false
+	}
+	yt := t.In(0)
+	if yt.Kind() != reflect.Func || yt.NumIn() != 1 || yt.NumOut() != 1 || yt.Out(0) != errorType {
+		return nil, false
+	}
+	return yt.In(0), true
 }
 
 func (a *apiFunc) prepIn() error {
@@ -84,6 +363,13 @@ func (a *apiFunc) prepIn() error {
 		a.hasInput = true
 		a.inputType = a.ft.In(offset + 2)
 	}
+	if a.hasInput {
+		a.elemType, a.hasStream = streamElem(a.inputType)
+	}
+	if a.hasInput && !a.hasStream && a.inputType.Kind() == reflect.Ptr {
+		a.hasInputPtr = true
+		a.inputType = a.inputType.Elem()
+	}
 	if a.ft.In(0) != contextType {
 		return errors.New("first argument must be context.Context")
 	}
@@ -93,7 +379,7 @@ func (a *apiFunc) prepIn() error {
 func (a *apiFunc) prepOut() error {
 	switch a.ft.NumOut() {
 	default:
-		return errors.New("must return 0, 1 or 2 values")
+		return errors.New("must return 0, 1 or 2 values, or (io.ReadCloser, int64, error)")
 	case 0:
 	case 1:
 		if a.ft.Out(0) != errorType {
@@ -106,11 +392,19 @@ func (a *apiFunc) prepOut() error {
 		}
 		a.hasOutput = true
 		a.hasOutputError = true
+	case 3:
+		// a stream of known length
+		if a.ft.Out(0) != readCloserType || a.ft.Out(1).Kind() != reflect.Int64 || a.ft.Out(2) != errorType {
+			return errors.New("three return values must be (io.ReadCloser, int64, error)")
+		}
+		a.hasOutput = true
+		a.hasOutputError = true
+		a.sized = true
 	}
 	return nil
 }
 
-func newAPIFunc(f interface{}) (*apiFunc, error) {
+func newAPIFunc(f interface{}, opts ...HandlerOption) (*apiFunc, error) {
 	af := apiFunc{f: f}
 	af.fv = reflect.ValueOf(f)
 	af.ft = af.fv.Type()
@@ -123,36 +417,421 @@ func newAPIFunc(f interface{}) (*apiFunc, error) {
 	if err := af.prepOut(); err != nil {
 		return nil, err
 	}
+	if af.hasInput {
+		inputType := af.inputType
+		if af.hasStream {
+			inputType = af.elemType
+		}
+		if err := checkJSONNames(inputType); err != nil {
+			return nil, err
+		}
+	}
+	if af.hasInput && !af.hasStream {
+		bindings, err := bindingsFor(af.inputType)
+		if err != nil {
+			return nil, err
+		}
+		af.bindings = bindings
+		for _, b := range bindings {
+			fromBody := b.jsonKey != ""
+			af.bodyBound = af.bodyBound || fromBody
+			af.bodyDefaults = af.bodyDefaults || fromBody && (b.def != nil || b.required)
+		}
+		af.validates = validates(af.inputType)
+		if af.timeFields, err = timeFieldsFor(af.inputType, map[reflect.Type]bool{}); err != nil {
+			return nil, err
+		}
+		if af.normalize, err = normalizes(af.inputType, map[reflect.Type]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	for _, opt := range opts {
+		opt(&af)
+	}
+	if af.schema != nil && (!af.hasInput || af.hasStream) {
+		return nil, errors.New("schema requires a decoded input argument")
+	}
+	if len(af.interceptors) > 0 && (!af.hasInput || af.hasStream) {
+		return nil, errors.New("input interceptor requires a decoded input argument")
+	}
+	if af.transform != nil && (!af.hasInput || af.hasStream) {
+		return nil, errors.New("body transform requires a decoded input argument")
+	}
+	if af.pooled && (!af.hasInput || af.hasStream) {
+		return nil, errors.New("pooled input requires a decoded input argument")
+	}
+	if af.readTimeout > 0 && af.hasStream {
+		return nil, errors.New("read timeout cannot bound a streamed input")
+	}
+	if af.coalescer != nil && af.hasRequest {
+		return nil, errors.New("coalescing requires a handler without *http.Request")
+	}
+	if af.dryRun == DryRunEcho && af.hasStream {
+		return nil, errors.New("dry run echo cannot replay a streamed input")
+	}
+	if af.textResponse && (!af.hasOutput || af.ft.Out(0).Kind() != reflect.String) {
+		return nil, errors.New("text response requires a string output")
+	}
+	if af.checkOutput && af.hasOutput {
+		out := af.ft.Out(0)
+		if err := checkMarshalable(out, out.String(), map[reflect.Type]bool{}); err != nil {
+			return nil, err
+		}
+	}
 	return &af, nil
 }
 
-func (m *Manager) we(f interface{}) (http.HandlerFunc, error) {
-	af, err := newAPIFunc(f)
+func isJSONMediaType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	return err == nil && (mt == "application/json" || strings.HasSuffix(mt, "+json"))
+}
+
+// checkJSONContentType rejects bodies explicitly labelled as something other
+// than JSON. A missing Content-Type is accepted.
+func checkJSONContentType(r *http.Request) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" && !isJSONMediaType(ct) {
+		return &Error{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: "expected application/json",
+		}
+	}
+	return nil
+}
+
+// WithAuthorization checks that the resolved account may call the handler.
+// An error that is not an *Error is reported as 403 Forbidden.
+func WithAuthorization(f func(ctx context.Context, accountID int) error) HandlerOption {
+	return func(a *apiFunc) {
+		a.authorize = f
+	}
+}
+
+func forbidden(err error) error {
+	if e, ok := unwrap(err).(*Error); ok {
+		return e
+	}
+	return &Error{Status: http.StatusForbidden, Message: "forbidden"}
+}
+
+// WithIgnoreBody lets a handler without an input argument accept and discard
+// a request body instead of rejecting it, for clients that always send one.
+func WithIgnoreBody() HandlerOption {
+	return func(a *apiFunc) {
+		a.ignoreBody = true
+	}
+}
+
+// WithUseNumber decodes numbers in interface{} input fields as json.Number,
+// preserving large integers. Manager.UseNumber enables it for all handlers.
+func WithUseNumber() HandlerOption {
+	return func(a *apiFunc) {
+		a.useNumber = true
+	}
+}
+
+func (m *Manager) newDecoder(r io.Reader, af *apiFunc) Decoder {
+	decoder := m.Codec.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if m.UseNumber || af.useNumber {
+		decoder.UseNumber()
+	}
+	return decoder
+}
+
+// WithContentTypes restricts the request media types a handler accepts.
+// Requests with a body of any other type are rejected with 415.
+func WithContentTypes(types ...string) HandlerOption {
+	return func(a *apiFunc) {
+		a.contentTypes = types
+	}
+}
+
+func checkContentTypes(r *http.Request, types []string) error {
+	if len(types) == 0 || r.ContentLength == 0 {
+		return nil
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	for _, t := range types {
+		if strings.EqualFold(mt, t) {
+			return nil
+		}
+	}
+	return &Error{
+		Status:  http.StatusUnsupportedMediaType,
+		Message: "expected " + strings.Join(types, " or "),
+	}
+}
+
+// decodeBody decodes the request body into v with the decoder registered
+// for its content type, or as JSON. When keys is true it also returns the
+// top-level keys of a JSON object body, lowercased.
+func (m *Manager) decodeBody(r *http.Request, af *apiFunc, v interface{}, keys bool) (map[string]bool, error) {
+	if af.transform != nil {
+		if err := af.transformBody(r); err != nil {
+			return nil, err
+		}
+	}
+	rejectNull := m.RejectNull || af.rejectNull
+	// schemas, null checks and the body keys binding precedence relies on
+	// only understand JSON, so other formats would slip past them;
+	// checkJSONContentType answers those with 415
+	if dec := m.requestDecoder(r); dec != nil && af.schema == nil && !rejectNull && !keys {
+		return nil, dec(r.Body, v)
+	}
+	if err := checkJSONContentType(r); err != nil {
+		return nil, err
+	}
+	body := m.limitDepth(r.Body)
+	var bodyKeys map[string]bool
+	if af.schema != nil || af.timeFields != nil || rejectNull || keys {
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		if af.schema != nil {
+			if err := m.validateSchema(af.schema, raw); err != nil {
+				return nil, err
+			}
+		}
+		if rejectNull {
+			if err := checkNulls(raw, reflect.TypeOf(v).Elem()); err != nil {
+				return nil, err
+			}
+		}
+		if keys {
+			bodyKeys = topLevelKeys(raw)
+		}
+		if raw, err = convertEpochs(raw, af.timeFields); err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(raw)
+	}
+	return bodyKeys, m.newDecoder(body, af).Decode(v)
+}
+
+func (m *Manager) decodeInput(r *http.Request, af *apiFunc) (reflect.Value, error) {
+	arg := af.newInput()
+	precedence := af.bindPrecedence
+	if precedence == 0 {
+		precedence = m.BindPrecedence
+	}
+	// defaults and required checks must know what the body set too
+	keys := precedence > PreferBound && af.bodyBound || af.bodyDefaults
+	bodyKeys, err := m.decodeBody(r, af, arg.Interface(), keys)
+	if err != nil {
+		// pointer inputs receive nil for an empty body
+		if err == io.EOF && af.hasInputPtr && len(af.bindings) == 0 {
+			return reflect.Zero(arg.Type()), af.intercept(r.Context(), reflect.Zero(arg.Type()))
+		}
+		// inputs bound entirely from cookies and the like may omit the body
+		if verr, ok := err.(*ValidationError); ok {
+			return arg, verr
+		}
+		if err != io.EOF || len(af.bindings) == 0 {
+			return arg, bodyError(err)
+		}
+	}
+	if err := m.bind(r, af, arg.Elem(), bodyKeys, precedence); err != nil {
+		return arg, err
+	}
+	if af.normalize {
+		normalizeValue(arg.Elem())
+	}
+	if af.validates {
+		if err := validateValue(arg); err != nil {
+			return arg, m.validationError(err)
+		}
+	}
+	return arg, af.intercept(r.Context(), arg)
+}
+
+func (m *Manager) accountID(r *http.Request) (int, error) {
+	if m.AccountIDResolver == nil {
+		return 1, nil
+	}
+	return m.AccountIDResolver.AccountID(r)
+}
+
+func (m *Manager) requestContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if m.BaseContext != nil {
+		ctx = m.BaseContext(r)
+		// keep the bag middleware has been filling for this request
+		if b, ok := r.Context().Value(bagKey{}).(*RequestBag); ok {
+			ctx = context.WithValue(ctx, bagKey{}, b)
+		}
+	}
+	if r.Pattern != "" {
+		ctx = context.WithValue(ctx, routePatternKey{}, r.Pattern)
+	}
+	ctx = m.snapshotFlags(ctx)
+	ctx = withBag(ctx)
+	return ctx
+}
+
+func (m *Manager) we(f interface{}, opts ...HandlerOption) (http.HandlerFunc, error) {
+	af, err := newAPIFunc(f, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		w = rec
+		ctx := context.WithValue(m.requestContext(r), startKey{}, start)
+		ctx = m.withRequestID(ctx, w, r)
+		ctx = context.WithValue(ctx, loggerKey{}, m.requestLogger(ctx, r))
+		ctx = context.WithValue(ctx, responseWriterKey{}, w)
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+			ctx = context.WithValue(ctx, ifMatchKey{}, ifMatch)
+		}
+		if af.fieldSelection {
+			ctx = withFieldSelection(ctx, r)
+		}
+		if af.dryRun != 0 && dryRunRequested(r) {
+			ctx = context.WithValue(ctx, dryRunKey{}, true)
+		}
+		r = r.WithContext(ctx)
+		if m.MaxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, m.MaxBodyBytes)
+		}
+		if af.deprecation != nil {
+			m.markDeprecated(w, r, af.deprecation)
+		}
+		trace := m.startTrace()
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		defer func() {
+			reqBytes := requestBytes(r, body)
+			m.observeSizes(r, af, rec, reqBytes)
+			if rec.status == http.StatusUnauthorized {
+				if inv, ok := m.AccountIDResolver.(interface{ Invalidate(*http.Request) }); ok {
+					inv.Invalidate(r)
+				}
+			}
+			elapsed := time.Since(start)
+			m.logAccess(r, af, rec, elapsed, reqBytes)
+			m.logTrace(r, trace, rec, elapsed, reqBytes)
+		}()
+		defer func() {
+			if v := recover(); v != nil {
+				m.recoverPanic(w, r, rec, v)
+			}
+		}()
+		if m.inMaintenance(r) {
+			m.SendError(w, r, errMaintenance)
+			return
+		}
+		release, ok := m.acquireSlot(r)
+		if !ok {
+			m.SendError(w, r, m.overloaded())
+			return
+		}
+		defer release()
+		// handlers that never see the account, such as health checks,
+		// don't depend on the resolver accepting the caller
+		var accountID int
+		var idemKey string
+		// dry runs must neither commit a response for the real request to
+		// replay nor share one with it
+		dryRun := DryRun(ctx)
+		if af.idempotency != nil && !dryRun {
+			idemKey = r.Header.Get("Idempotency-Key")
+		}
+		if af.needsAccount() || idemKey != "" {
+			var err error
+			if accountID, err = m.accountID(r); err != nil {
+				m.SendError(w, r, err)
+				return
+			}
+			if af.rejectsAnonymous(accountID) {
+				m.SendError(w, r, errAnonymousAccount)
+				return
+			}
+			if af.hasAccountID {
+				ctx = context.WithValue(ctx, loggerKey{}, LoggerFromContext(ctx).With("account_id", accountID))
+				r = r.WithContext(ctx)
+			}
+		}
+		if af.rateLimiter != nil && !m.rateLimit(w, r, af.rateLimiter) {
+			return
+		}
+		// reject declared oversize bodies before reading any of them;
+		// chunked bodies are caught by the MaxBytesReader as they stream
+		if m.MaxBodyBytes > 0 && r.ContentLength > m.MaxBodyBytes {
+			m.SendError(w, r, bodyError(&http.MaxBytesError{Limit: m.MaxBodyBytes}))
+			return
+		}
+		if err := checkContentTypes(r, af.contentTypes); err != nil {
+			m.SendError(w, r, err)
+			return
+		}
+		// the read deadline covers every read of the body: signature
+		// checks and idempotency fingerprints read it before decoding
+		var readBody *deadlineBody
+		if af.readTimeout > 0 {
+			var clear func()
+			readBody, clear = withReadDeadline(rec.ResponseWriter, r, af.readTimeout)
+			defer clear()
+		}
+		if af.signature != nil {
+			if err := af.signature.verify(r); err != nil {
+				m.SendError(w, r, readError(w, readBody, err))
+				return
+			}
+		}
+		if af.authorize != nil {
+			if err := af.authorize(ctx, accountID); err != nil {
+				m.SendError(w, r, forbidden(err))
+				return
+			}
+		}
+		if idemKey != "" {
+			finish, ok := m.reserveIdempotent(w, r, af, rec, idemKey, accountID, readBody)
+			if !ok {
+				return
+			}
+			defer finish()
+		}
+		if af.coalescer != nil && !dryRun {
+			if key, ok := coalesceKey(r, af, accountID); ok {
+				finish, ok := m.coalesce(w, r, af.coalescer, rec, key)
+				if !ok {
+					return
+				}
+				defer finish()
+			}
+		}
 		in := []reflect.Value{reflect.ValueOf(ctx)}
 		if af.hasRequest {
 			in = append(in, reflect.ValueOf(r))
 		}
 		if af.hasAccountID {
-			accountID := 1
 			in = append(in, reflect.ValueOf(accountID))
 		}
-		if af.hasInput {
-			arg := reflect.New(af.inputType)
-			decoder := json.NewDecoder(r.Body)
-			decoder.DisallowUnknownFields()
-			if err := decoder.Decode(arg.Interface()); err != nil {
-				m.SendError(w, r, &Error{
-					Status:  http.StatusBadRequest,
-					Message: err.Error(),
-				})
+		if af.hasStream {
+			ndjson := isNDJSONMediaType(r.Header.Get("Content-Type"))
+			if err := checkJSONContentType(r); err != nil && !ndjson {
+				m.SendError(w, r, err)
+				return
+			}
+			in = append(in, m.streamInput(r.Body, af, ndjson))
+		} else if af.hasInput {
+			done := trace.phase("decode")
+			arg, err := m.decodeInput(r, af)
+			done()
+			defer af.releaseInput(arg)
+			if err != nil {
+				m.SendError(w, r, readError(w, readBody, err))
 				return
 			}
-			in = append(in, arg.Elem())
+			if af.hasInputPtr {
+				in = append(in, arg)
+			} else {
+				in = append(in, arg.Elem())
+			}
+		} else if af.ignoreBody {
+			_, _ = io.Copy(io.Discard, r.Body)
 		} else {
 			var b [1]byte
 			n, err := r.Body.Read(b[:])
@@ -164,62 +843,310 @@ func (m *Manager) we(f interface{}) (http.HandlerFunc, error) {
 				return
 			}
 		}
+		if af.dryRun == DryRunEcho && DryRun(ctx) {
+			if af.hasInput {
+				m.sendJSON(w, r, http.StatusOK, in[len(in)-1].Interface())
+			} else {
+				m.sendEmpty(w, m.EmptyStatus)
+			}
+			return
+		}
+		failed := true
+		if af.breaker != nil {
+			done, retry := af.breaker.acquire()
+			if done == nil {
+				m.SendError(w, r, &Error{
+					Status:     http.StatusServiceUnavailable,
+					Message:    "service temporarily unavailable",
+					Retryable:  true,
+					RetryAfter: retry,
+				})
+				return
+			}
+			defer func() {
+				done(failed)
+			}()
+		}
+		callCtx := ctx
+		if af.processTimeout > 0 {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithTimeoutCause(ctx, af.processTimeout, errProcessTimeout)
+			defer cancel()
+			in[0] = reflect.ValueOf(callCtx)
+			if af.hasRequest {
+				in[1] = reflect.ValueOf(r.WithContext(callCtx))
+			}
+		}
+		done := trace.phase("handler")
 		out := af.fv.Call(in)
+		done()
+		failed = false
+		if af.hasOutputError {
+			err, _ := out[len(out)-1].Interface().(error)
+			failed = breakerFailure(err)
+		}
 		if af.hasOutputError {
 			err := out[len(out)-1]
+			// a non-nil error always wins over any output value
 			if !err.IsNil() {
+				if af.sized {
+					if rc, ok := out[0].Interface().(io.ReadCloser); ok && rc != nil {
+						rc.Close()
+					}
+				}
+				if m.StrictOutputs && af.hasOutput && !out[0].IsZero() {
+					LoggerFromContext(ctx).Warn("handler returned both output and error",
+						"error", err.Interface())
+				}
 				e := unwrap(err.Interface())
-				if e, ok := e.(*Error); ok {
+				if e == ResponseWritten {
+					return
+				}
+				if af.processTimeout > 0 && processTimedOut(callCtx, err.Interface().(error)) {
+					m.SendError(w, r, errProcessTimeout)
+					return
+				}
+				switch e := e.(type) {
+				case *Error, *Problem, *ValidationError:
 					m.SendError(w, r, e)
-				} else {
+				default:
 					m.SendError(w, r, err.Interface().(error))
 				}
 				return
 			}
 		}
-		if af.hasOutput {
-			m.sendJSON(w, r, http.StatusOK, out[0].Interface())
+		if af.hasOutput && af.responseLog {
+			LoggerFromContext(ctx).Info("response", "body", redact(out[0], map[uintptr]bool{}))
+		}
+		defer trace.phase("encode")()
+		if m.noContent(r, af) {
+			if af.sized {
+				if rc, ok := out[0].Interface().(io.ReadCloser); ok && rc != nil {
+					rc.Close()
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		} else if af.sized {
+			m.writeSized(w, r, out[0].Interface(), out[1].Int())
+		} else if af.hasOutput && af.textResponse {
+			sendText(w, out[0].String())
+		} else if af.hasOutput && af.template != nil && af.template.wanted(r) {
+			m.writeTemplate(w, r, af.template, out[0].Interface())
+		} else if af.hasOutput {
+			m.writeOutput(w, r, out[0].Interface())
 		} else {
-			w.Header().Add("Content-Type", jsonCT)
-			_, _ = w.Write(emptyJSON)
+			m.sendEmpty(w, m.EmptyStatus)
 		}
 	}, nil
 }
 
+// streamInput builds the iterator passed to streaming handlers. Each call to
+// the iterator decodes the request body, either a JSON array or newline
+// delimited JSON, one element at a time. yield runs synchronously on the
+// request goroutine before the next element is read, so memory use is
+// bounded by the decoder's buffer plus a single element, and a slow consumer
+// stops reading from the connection, letting TCP flow control push back on
+// the client. MaxBodyBytes still applies to the body as a whole.
+func (m *Manager) streamInput(body io.Reader, af *apiFunc, ndjson bool) reflect.Value {
+	decoder := m.newDecoder(m.limitDepth(body), af)
+	return reflect.MakeFunc(af.inputType, func(args []reflect.Value) []reflect.Value {
+		var err error
+		if ndjson {
+			err = decodeLines(decoder, af.elemType, args[0])
+		} else {
+			err = decodeStream(decoder, af.elemType, args[0])
+		}
+		return []reflect.Value{reflect.ValueOf(&err).Elem()}
+	})
+}
+
+func isNDJSONMediaType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	return err == nil && (mt == "application/x-ndjson" || mt == "application/jsonl")
+}
+
+// bodyError maps an error reading or decoding the request body to the
+// response it warrants.
+func bodyError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return &Error{Status: http.StatusRequestEntityTooLarge, Message: "request body too large"}
+	}
+	return &Error{Status: http.StatusBadRequest, Message: err.Error()}
+}
+
+func elemError(i int, err error) *Error {
+	e := bodyError(err)
+	if e.Status == http.StatusBadRequest {
+		e.Message = fmt.Sprintf("element %d: %s", i, e.Message)
+	}
+	return e
+}
+
+func decodeStream(decoder Decoder, elemType reflect.Type, yield reflect.Value) error {
+	tok, err := decoder.Token()
+	if err != nil {
+		return bodyError(err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return &Error{Status: http.StatusBadRequest, Message: "expected JSON array"}
+	}
+	for i := 0; decoder.More(); i++ {
+		elem := reflect.New(elemType)
+		if err := decoder.Decode(elem.Interface()); err != nil {
+			return elemError(i, err)
+		}
+		if out := yield.Call([]reflect.Value{elem.Elem()}); !out[0].IsNil() {
+			return out[0].Interface().(error)
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return bodyError(err)
+	}
+	return nil
+}
+
+func decodeLines(decoder Decoder, elemType reflect.Type, yield reflect.Value) error {
+	for i := 0; ; i++ {
+		elem := reflect.New(elemType)
+		if err := decoder.Decode(elem.Interface()); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return elemError(i, err)
+		}
+		if out := yield.Call([]reflect.Value{elem.Elem()}); !out[0].IsNil() {
+			return out[0].Interface().(error)
+		}
+	}
+}
+
+// WithTextResponse writes a string output as text/plain instead of as a
+// JSON string.
+func WithTextResponse() HandlerOption {
+	return func(a *apiFunc) {
+		a.textResponse = true
+	}
+}
+
+func sendText(w http.ResponseWriter, s string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, s)
+}
+
+func (m *Manager) sendEmpty(w http.ResponseWriter, status int) {
+	if m.EmptyContentType != "" && len(m.EmptyBody) > 0 {
+		w.Header().Add("Content-Type", m.EmptyContentType)
+	}
+	w.WriteHeader(status)
+	if len(m.EmptyBody) > 0 {
+		_, _ = w.Write(m.EmptyBody)
+	}
+}
+
 func (m *Manager) sendJSON(
 	w http.ResponseWriter,
 	r *http.Request,
 	status int,
 	v interface{},
 ) {
+	if rv := reflect.ValueOf(v); !m.NilSliceAsNull && rv.Kind() == reflect.Slice && rv.IsNil() {
+		v = reflect.MakeSlice(rv.Type(), 0, 0).Interface()
+	}
+	if m.Canonical {
+		b, err := m.canonicalJSON(v)
+		if err != nil {
+			LoggerFromContext(r.Context()).Error("error encoding response", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", jsonCT)
+		w.WriteHeader(status)
+		_, _ = w.Write(b)
+		return
+	}
 	w.Header().Add("Content-Type", jsonCT)
 	w.WriteHeader(status)
-	encoder := json.NewEncoder(w)
+	encoder := m.Codec.NewEncoder(w)
+	if indent := m.indent(); indent != "" {
+		encoder.SetIndent("", indent)
+	}
 	if err := encoder.Encode(v); err != nil {
+		LoggerFromContext(r.Context()).Error("error encoding response", "error", err)
 		return
 	}
 }
 
+func (m *Manager) indent() string {
+	if m.DisablePretty {
+		return ""
+	}
+	return m.Indent
+}
+
+type errorBody struct {
+	Error      string `json:"error"`
+	Code       string `json:"code,omitempty"`
+	Retryable  bool   `json:"retryable,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+
+	Errors []FieldError `json:"errors,omitempty"`
+
+	TraceID   string     `json:"trace_id,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	DocsURL   string     `json:"docs_url,omitempty"`
+}
+
 func (m *Manager) SendError(w http.ResponseWriter, r *http.Request, err error) {
+	if p, ok := err.(*Problem); ok {
+		m.sendProblem(w, r, p)
+		return
+	}
 	status := http.StatusInternalServerError
-	message := "Internal Server Error"
+	body := errorBody{Error: "Internal Server Error"}
 
-	if apierr, ok := err.(*Error); ok {
+	if verr, ok := err.(*ValidationError); ok {
+		status = m.ValidationStatus
+		if verr.Status != 0 {
+			status = verr.Status
+		}
+		body.Error = verr.Error()
+		body.Errors = verr.Errors
+	} else if apierr, ok := err.(*Error); ok {
 		status = apierr.Status
-		message = apierr.Message
+		body.Error = apierr.Message
+		body.Code = apierr.Code
+		if msg, ok := m.localize(r, apierr.Code); ok {
+			body.Error = msg
+		}
+		body.Retryable = apierr.Retryable
+		if apierr.RetryAfter > 0 {
+			body.RetryAfter = int((apierr.RetryAfter + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(body.RetryAfter))
+		}
+	} else {
+		LoggerFromContext(r.Context()).Error("internal server error", "error", err)
 	}
 
-	m.sendJSON(w, r, status, struct {
-		Error string `json:"error"`
-	}{
-		Error: message,
-	})
+	m.ErrorMetadata.apply(r, &body)
+	m.sendJSON(w, r, status, body)
+}
+
+func (m *Manager) TryW(f interface{}, opts ...HandlerOption) (http.HandlerFunc, error) {
+	hf, err := m.we(f, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error binding API function %T: %w", f, err)
+	}
+	return hf, nil
 }
 
-func (m *Manager) W(f interface{}) http.HandlerFunc {
-	hf, err := m.we(f)
+func (m *Manager) W(f interface{}, opts ...HandlerOption) http.HandlerFunc {
+	hf, err := m.TryW(f, opts...)
 	if err != nil {
-		panic(fmt.Errorf("error binding API function %T: %+v", f, err))
+		panic(err)
 	}
 	return hf
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+type widget struct {
+	ID int `json:"id"`
+}
+
+func TestErrorWinsOverOutput(t *testing.T) {
+	var logs bytes.Buffer
+	m := NewManager(slog.New(slog.NewTextHandler(&logs, nil)))
+	m.StrictOutputs = true
+	m.Route("GET /widgets/{id}", func(ctx context.Context) (widget, error) {
+		return widget{ID: 7}, &Error{Status: http.StatusConflict, Code: "conflict", Message: "widget is locked"}
+	})
+
+	w := serve(m, "GET", "/widgets/7", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding body %q: %v", w.Body, err)
+	}
+	if body["error"] != "widget is locked" || body["code"] != "conflict" {
+		t.Errorf("body = %s, want the error", w.Body)
+	}
+	if _, ok := body["id"]; ok {
+		t.Errorf("body = %s, output leaked into the error response", w.Body)
+	}
+	if !strings.Contains(logs.String(), "handler returned both output and error") {
+		t.Errorf("no StrictOutputs warning logged, got:\n%s", logs.String())
+	}
+}
+
+func TestErrorWinsWithoutStrictOutputs(t *testing.T) {
+	var logs bytes.Buffer
+	m := NewManager(slog.New(slog.NewTextHandler(&logs, nil)))
+	m.Route("GET /widgets/{id}", func(ctx context.Context) (widget, error) {
+		return widget{ID: 7}, &Error{Status: http.StatusConflict, Message: "widget is locked"}
+	})
+
+	w := serve(m, "GET", "/widgets/7", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if strings.Contains(logs.String(), "handler returned both output and error") {
+		t.Errorf("warning logged without StrictOutputs:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// RequestBag carries values between middleware and handlers for one request
+// without each defining its own context key type.
+type RequestBag struct {
+	mu     sync.RWMutex
+	values map[interface{}]interface{}
+}
+
+func (b *RequestBag) Set(key, val interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.values[key] = val
+}
+
+func (b *RequestBag) Get(key interface{}) (interface{}, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	val, ok := b.values[key]
+	return val, ok
+}
+
+type bagKey struct{}
+
+// Bag returns the request's bag. Outside of a request set up by WithBag or
+// the harness it returns a fresh, unshared bag.
+func Bag(ctx context.Context) *RequestBag {
+	if b, ok := ctx.Value(bagKey{}).(*RequestBag); ok {
+		return b
+	}
+	return &RequestBag{values: map[interface{}]interface{}{}}
+}
+
+// BagValue is a typed accessor for Bag(ctx).Get.
+func BagValue[T any](ctx context.Context, key interface{}) (T, bool) {
+	val, ok := Bag(ctx).Get(key)
+	t, ok2 := val.(T)
+	return t, ok && ok2
+}
+
+func withBag(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(bagKey{}).(*RequestBag); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, bagKey{}, &RequestBag{values: map[interface{}]interface{}{}})
+}
+
+// WithBag is middleware installing the request bag ahead of the handler
+// harness, so middleware further in can populate it for handlers. The
+// Manager's own ServeHTTP does this already; WithBag is for handlers from W
+// mounted on another router.
+func WithBag(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(withBag(r.Context())))
+	})
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// bindSource fills tagged input fields from a part of the request other
+// than the body. values returns every value present for name.
+type bindSource struct {
+	tag    string
+	values func(r *http.Request, name string) []string
+}
+
+var bindSources = []bindSource{
+	{tag: "cookie", values: cookieValues},
+	{tag: "query", values: queryValues},
+	{tag: "header", values: headerValues},
+	{tag: "path", values: pathValues},
+}
+
+func headerValues(r *http.Request, name string) []string {
+	return r.Header.Values(name)
+}
+
+// pathValues reads a wildcard from the routed pattern. Values, including the
+// remainder matched by a "{name...}" wildcard, are already unescaped.
+func pathValues(r *http.Request, name string) []string {
+	if v := r.PathValue(name); v != "" {
+		return []string{v}
+	}
+	return nil
+}
+
+func queryValues(r *http.Request, name string) []string {
+	return r.URL.Query()[name]
+}
+
+func cookieValues(r *http.Request, name string) []string {
+	var vals []string
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			vals = append(vals, c.Value)
+		}
+	}
+	return vals
+}
+
+type fieldBinding struct {
+	source   *bindSource
+	name     string
+	index    []int
+	required bool
+	slice    bool
+	comma    bool
+	def      *string
+	// jsonKey is the body key that also sets the field, if any
+	jsonKey string
+}
+
+// BindPrecedence resolves a field set both by the body and by a bound
+// request value.
+type BindPrecedence int
+
+const (
+	PreferBound BindPrecedence = iota + 1
+	PreferBody
+	// RejectConflict answers 400 when both set the field.
+	RejectConflict
+)
+
+func WithBindPrecedence(p BindPrecedence) HandlerOption {
+	return func(a *apiFunc) {
+		a.bindPrecedence = p
+	}
+}
+
+// topLevelKeys returns the lowercased keys of a JSON object, matching the
+// case-insensitive way encoding/json assigns them to fields.
+func topLevelKeys(raw []byte) map[string]bool {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil
+	}
+	keys := make(map[string]bool, len(obj))
+	for k := range obj {
+		keys[strings.ToLower(k)] = true
+	}
+	return keys
+}
+
+// boundHeaders lists the header and cookie values bound into the input, the
+// parts of the request outside its URL and body that the handler sees.
+func (af *apiFunc) boundHeaders(r *http.Request) string {
+	var b strings.Builder
+	for _, fb := range af.bindings {
+		if fb.source.tag != "header" && fb.source.tag != "cookie" {
+			continue
+		}
+		b.WriteString("\n" + fb.source.tag + " " + fb.name + ": ")
+		b.WriteString(strings.Join(fb.source.values(r, fb.name), ", "))
+	}
+	return b.String()
+}
+
+func bindingsFor(t reflect.Type) ([]fieldBinding, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	var bs []fieldBinding
+	if err := collectBindings(t, nil, map[reflect.Type]bool{}, &bs); err != nil {
+		return nil, err
+	}
+	return resolveBindings(bs)
+}
+
+// collectBindings gathers tagged fields of t, descending into embedded
+// structs the same way encoding/json promotes their fields.
+func collectBindings(t reflect.Type, index []int, visiting map[reflect.Type]bool, bs *[]fieldBinding) error {
+	if visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		idx := append(append([]int(nil), index...), i)
+		tagged := false
+		for j := range bindSources {
+			src := &bindSources[j]
+			tag, ok := f.Tag.Lookup(src.tag)
+			if !ok {
+				continue
+			}
+			tagged = true
+			if !f.IsExported() {
+				return fmt.Errorf("field %s: %s tag on unexported field", f.Name, src.tag)
+			}
+			b, err := newFieldBinding(f, src, tag)
+			if err != nil {
+				return err
+			}
+			b.index = idx
+			*bs = append(*bs, b)
+		}
+		if tagged || !f.Anonymous {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct {
+			continue
+		}
+		n := len(*bs)
+		if err := collectBindings(ft, idx, visiting, bs); err != nil {
+			return err
+		}
+		if len(*bs) > n && f.Type.Kind() == reflect.Ptr && !f.IsExported() {
+			return fmt.Errorf("field %s: cannot bind through unexported embedded pointer", f.Name)
+		}
+	}
+	return nil
+}
+
+func newFieldBinding(f reflect.StructField, src *bindSource, tag string) (fieldBinding, error) {
+	slice := isSliceField(f.Type)
+	if slice && !canBind(f.Type.Elem()) || !slice && !canBind(f.Type) {
+		return fieldBinding{}, fmt.Errorf("field %s: cannot bind type %s", f.Name, f.Type)
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if src.tag == "path" {
+		// "{path...}" wildcards are looked up by their bare name
+		name = strings.TrimSuffix(name, "...")
+	}
+	if name == "" {
+		name = f.Name
+	}
+	b := fieldBinding{source: src, name: name, slice: slice}
+	for _, opt := range strings.Split(opts, ",") {
+		switch opt {
+		case "":
+		case "required":
+			b.required = true
+		case "comma":
+			if !slice {
+				return b, fmt.Errorf("field %s: comma option requires a slice", f.Name)
+			}
+			b.comma = true
+		default:
+			return b, fmt.Errorf("field %s: unknown %s tag option %q", f.Name, src.tag, opt)
+		}
+	}
+	if d, ok := f.Tag.Lookup("default"); ok {
+		b.def = &d
+	}
+	if tag := f.Tag.Get("json"); tag != "-" {
+		key, _, _ := strings.Cut(tag, ",")
+		if key == "" {
+			key = f.Name
+		}
+		b.jsonKey = strings.ToLower(key)
+	}
+	return b, nil
+}
+
+// resolveBindings applies Go's promotion rules when several fields bind the
+// same source and name: the shallowest field wins, and a tie at that depth
+// is an error. Deeper ties don't matter once a shallower field exists.
+func resolveBindings(bs []fieldBinding) ([]fieldBinding, error) {
+	type key struct{ tag, name string }
+	best := map[key]int{}
+	tied := map[key]bool{}
+	for i, b := range bs {
+		k := key{b.source.tag, b.name}
+		j, ok := best[k]
+		switch {
+		case !ok || len(b.index) < len(bs[j].index):
+			best[k] = i
+			tied[k] = false
+		case len(b.index) == len(bs[j].index):
+			tied[k] = true
+		}
+	}
+	var resolved []fieldBinding
+	for i, b := range bs {
+		k := key{b.source.tag, b.name}
+		if best[k] != i {
+			continue
+		}
+		if tied[k] {
+			return nil, fmt.Errorf("%s %q is bound by more than one field", b.source.tag, b.name)
+		}
+		resolved = append(resolved, b)
+	}
+	return resolved, nil
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex, allocating nil embedded
+// pointers along the way.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func isSliceField(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+func canBind(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return canBind(t.Elem())
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// bind fills the bound fields of v, collecting every failure into a
+// ValidationError sent with 400. bodyKeys, when known, holds the keys the
+// body set: those fields take no default and aren't missing, and clash with
+// bound values according to precedence.
+func (m *Manager) bind(r *http.Request, af *apiFunc, v reflect.Value, bodyKeys map[string]bool, precedence BindPrecedence) error {
+	var errs []FieldError
+	for _, b := range af.bindings {
+		vals := b.source.values(r, b.name)
+		if b.jsonKey != "" && bodyKeys[b.jsonKey] {
+			switch {
+			case len(vals) == 0:
+				// the body set the field: it is neither missing nor
+				// overridden by a default
+				continue
+			case precedence == PreferBody:
+				continue
+			case precedence == RejectConflict:
+				errs = append(errs, FieldError{
+					Field:   b.name,
+					Source:  b.source.tag,
+					Code:    "conflict",
+					Message: "also set in the body",
+				})
+				continue
+			}
+		}
+		if len(vals) == 0 {
+			if b.required {
+				errs = append(errs, FieldError{
+					Field:   b.name,
+					Source:  b.source.tag,
+					Code:    "required",
+					Message: "required",
+				})
+				continue
+			}
+			if b.def == nil {
+				continue
+			}
+			vals = []string{*b.def}
+		}
+		field := fieldByIndex(v, b.index)
+		if b.slice {
+			if fe := setSlice(field, b, vals); fe != nil {
+				errs = append(errs, *fe)
+			}
+			continue
+		}
+		if err := setField(field, vals[0]); err != nil {
+			errs = append(errs, FieldError{
+				Field:   b.name,
+				Source:  b.source.tag,
+				Code:    "invalid",
+				Message: err.Error(),
+			})
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Status: http.StatusBadRequest, Errors: errs}
+	}
+	return nil
+}
+
+// setSlice fills a slice field from every value, each split on commas when
+// the binding has the comma option. Empty values add no elements, so
+// ?ids= binds an empty slice.
+func setSlice(v reflect.Value, b fieldBinding, vals []string) *FieldError {
+	var elems []string
+	for _, val := range vals {
+		if val == "" {
+			continue
+		}
+		if !b.comma {
+			elems = append(elems, val)
+			continue
+		}
+		for _, e := range strings.Split(val, ",") {
+			elems = append(elems, strings.TrimSpace(e))
+		}
+	}
+	sl := reflect.MakeSlice(v.Type(), len(elems), len(elems))
+	for i, s := range elems {
+		if err := setField(sl.Index(i), s); err != nil {
+			return &FieldError{
+				Field:   fmt.Sprintf("%s[%d]", b.name, i),
+				Source:  b.source.tag,
+				Code:    "invalid",
+				Message: fmt.Sprintf("%q: %s", s, err),
+			}
+		}
+	}
+	v.Set(sl)
+	return nil
+}
+
+func setField(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setField(v.Elem(), s)
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("invalid boolean")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("invalid integer")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("invalid unsigned integer")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return errors.New("invalid number")
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
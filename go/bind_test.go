@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type Sorting struct {
+	Order string `query:"order"`
+	Limit int    `query:"limit"`
+}
+
+type Paging struct {
+	Sorting
+	Limit int `query:"limit"`
+	Page  int `query:"page"`
+}
+
+type listQuery struct {
+	Paging
+	Page int `query:"page"`
+}
+
+func TestBindShallowestFieldWins(t *testing.T) {
+	m := NewManager(slog.Default())
+	var got listQuery
+	m.Route("GET /items", func(ctx context.Context, q listQuery) error {
+		got = q
+		return nil
+	})
+
+	w := serve(m, "GET", "/items?page=2&limit=10&order=asc", "")
+	if w.Code != 200 {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	want := listQuery{Page: 2, Paging: Paging{Limit: 10, Sorting: Sorting{Order: "asc"}}}
+	if got != want {
+		t.Errorf("bound %+v, want %+v", got, want)
+	}
+}
+
+type Left struct {
+	Q string `query:"q"`
+}
+
+type Right struct {
+	Q string `query:"q"`
+}
+
+func TestBindSameDepthTie(t *testing.T) {
+	type tied struct {
+		Left
+		Right
+	}
+	_, err := bindingsFor(reflect.TypeOf(tied{}))
+	if err == nil || !strings.Contains(err.Error(), `query "q" is bound by more than one field`) {
+		t.Fatalf("err = %v, want a conflict on query q", err)
+	}
+
+	// a shallower field settles the tie
+	type settled struct {
+		Left
+		Right
+		Q string `query:"q"`
+	}
+	bs, err := bindingsFor(reflect.TypeOf(settled{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bs) != 1 || !reflect.DeepEqual(bs[0].index, []int{2}) {
+		t.Errorf("bindings = %+v, want only the outer Q", bs)
+	}
+}
+
+type Window struct {
+	From int `query:"from"`
+}
+
+type Range struct {
+	*Window
+}
+
+type rangeQuery struct {
+	*Range
+	Name string `json:"name"`
+}
+
+func TestBindAllocatesEmbeddedPointers(t *testing.T) {
+	m := NewManager(slog.Default())
+	var got rangeQuery
+	m.Route("POST /ranges", func(ctx context.Context, q rangeQuery) error {
+		got = q
+		return nil
+	})
+
+	w := serve(m, "POST", "/ranges?from=5", `{"name":"a"}`, "Content-Type", "application/json")
+	if w.Code != 200 {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if got.Range == nil || got.Window == nil || got.From != 5 || got.Name != "a" {
+		b, _ := json.Marshal(got)
+		t.Errorf("bound %s, want from=5 through allocated pointers", b)
+	}
+
+	got = rangeQuery{}
+	serve(m, "POST", "/ranges", `{"name":"a"}`, "Content-Type", "application/json")
+	if got.Range != nil {
+		t.Errorf("Range allocated without a bound value: %+v", got.Range)
+	}
+}
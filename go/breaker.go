@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitBreaker opens once at least MinRequests calls within Window have
+// failed at ErrorRate or above. While open, calls are rejected with 503;
+// after Cooldown a single trial call is let through, closing the breaker if
+// it succeeds and reopening it otherwise.
+type CircuitBreaker struct {
+	ErrorRate   float64
+	MinRequests int
+	Window      time.Duration
+	Cooldown    time.Duration
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	openedAt    time.Time
+	requests    int
+	failures    int
+	probing     bool
+}
+
+func NewCircuitBreaker(errorRate float64, minRequests int, window, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		ErrorRate:   errorRate,
+		MinRequests: minRequests,
+		Window:      window,
+		Cooldown:    cooldown,
+	}
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// acquire reports whether a call may proceed. When it may, done must be
+// called with the outcome; otherwise retry is how long until the breaker
+// will next let a call through.
+func (b *CircuitBreaker) acquire() (done func(failed bool), retry time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.state {
+	case BreakerOpen:
+		if wait := b.Cooldown - now.Sub(b.openedAt); wait > 0 {
+			return nil, wait
+		}
+		b.state = BreakerHalfOpen
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			return nil, b.Cooldown
+		}
+		b.probing = true
+		return b.probeDone, 0
+	}
+	if now.Sub(b.windowStart) >= b.Window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	return b.record, 0
+}
+
+func (b *CircuitBreaker) probeDone(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if failed {
+		b.state, b.openedAt = BreakerOpen, time.Now()
+		return
+	}
+	b.state = BreakerClosed
+	b.windowStart, b.requests, b.failures = time.Now(), 0, 0
+}
+
+func (b *CircuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerClosed {
+		return
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= b.MinRequests && float64(b.failures) >= b.ErrorRate*float64(b.requests) {
+		b.state, b.openedAt = BreakerOpen, time.Now()
+	}
+}
+
+// WithCircuitBreaker counts errors returned by the handler, other than
+// client errors, towards b.
+func WithCircuitBreaker(b *CircuitBreaker) HandlerOption {
+	return func(a *apiFunc) {
+		a.breaker = b
+	}
+}
+
+func breakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch e := unwrap(err).(type) {
+	case *Error:
+		return e.Status >= 500
+	case *Problem:
+		return e.status() >= 500
+	case *ValidationError:
+		return false
+	}
+	return err != ResponseWritten
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// canonicalJSON re-encodes the codec's output of v in a fixed form: object
+// keys sorted, no insignificant whitespace beyond the indent, and numbers
+// written the way encoding/json writes them. The result depends only on the
+// logical value, not on field order or on which codec produced it.
+func (m *Manager) canonicalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.Codec.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	decoder := json.NewDecoder(&buf)
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	buf.Reset()
+	encoder := json.NewEncoder(&buf)
+	if indent := m.indent(); indent != "" {
+		encoder.SetIndent("", indent)
+	}
+	if err := encoder.Encode(canonicalValue(doc)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func canonicalValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = canonicalValue(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = canonicalValue(e)
+		}
+	case json.Number:
+		return canonicalNumber(v)
+	}
+	return v
+}
+
+// canonicalNumber rewrites n in the form encoding/json uses for floats,
+// plain decimals between 1e-6 and 1e21 and exponents outside, but from its
+// decimal digits rather than a float64, so no precision is lost.
+func canonicalNumber(n json.Number) json.Number {
+	if i, ok := new(big.Int).SetString(string(n), 10); ok {
+		return json.Number(i.String())
+	}
+	s := string(n)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	mantissa, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(strings.TrimPrefix(s[i+1:], "+"))
+		if err != nil {
+			return n
+		}
+		mantissa, exp = s[:i], e
+	}
+	whole, frac, _ := strings.Cut(mantissa, ".")
+	// the value is digits × 10^exp
+	digits := strings.TrimLeft(whole+frac, "0")
+	exp -= len(frac)
+	trimmed := strings.TrimRight(digits, "0")
+	exp += len(digits) - len(trimmed)
+	digits = trimmed
+	if digits == "" {
+		return json.Number(sign + "0")
+	}
+	// point is where the decimal point falls in 0.digits × 10^point
+	point := len(digits) + exp
+	switch {
+	case point > 21 || point < -5:
+		out := digits[:1]
+		if len(digits) > 1 {
+			out += "." + digits[1:]
+		}
+		e := strconv.Itoa(point - 1)
+		if point-1 >= 0 {
+			e = "+" + e
+		}
+		return json.Number(sign + out + "e" + e)
+	case point <= 0:
+		return json.Number(sign + "0." + strings.Repeat("0", -point) + digits)
+	case point >= len(digits):
+		return json.Number(sign + digits + strings.Repeat("0", point-len(digits)))
+	}
+	return json.Number(sign + digits[:point] + "." + digits[point:])
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type flight struct {
+	done chan struct{}
+	resp *StoredResponse
+}
+
+type coalescer struct {
+	mu       sync.Mutex
+	inflight map[string]*flight
+}
+
+// WithCoalescing shares one handler call between identical concurrent GET
+// and HEAD requests: followers wait for the leader and receive a copy of its
+// response. Requests are identical when they share the URL, the caller's
+// account, the varyHeaders and any headers or cookies bound into the input,
+// so it suits read-only handlers whose response depends on nothing else.
+// Handlers taking the *http.Request could depend on anything and are
+// refused.
+func WithCoalescing() HandlerOption {
+	return func(a *apiFunc) {
+		a.coalescer = &coalescer{inflight: map[string]*flight{}}
+	}
+}
+
+// varyHeaders change the response of an otherwise identical request:
+// conditional requests, ranges and content negotiation.
+var varyHeaders = []string{
+	"Accept",
+	"Accept-Language",
+	"If-Modified-Since",
+	"If-None-Match",
+	"If-Range",
+	"Range",
+}
+
+func coalesceKey(r *http.Request, af *apiFunc, accountID int) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteString(r.Method + " " + r.URL.RequestURI())
+	if af.hasAccountID {
+		b.WriteString(" " + strconv.Itoa(accountID))
+	}
+	for _, h := range varyHeaders {
+		b.WriteString("\n" + strings.Join(r.Header.Values(h), ", "))
+	}
+	b.WriteString(af.boundHeaders(r))
+	return b.String(), true
+}
+
+// coalesce returns false when the request was answered from another's
+// in-flight result. Otherwise the request leads, and finish must be called
+// once the handler has responded.
+func (m *Manager) coalesce(w http.ResponseWriter, r *http.Request, c *coalescer, rec *responseRecorder, key string) (finish func(), ok bool) {
+	c.mu.Lock()
+	if f, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		start := time.Now()
+		select {
+		case <-f.done:
+		case <-r.Context().Done():
+			return nil, false
+		}
+		if m.Metrics != nil {
+			m.Metrics.ObserveCoalesced(RoutePatternFromContext(r.Context()))
+		}
+		LoggerFromContext(r.Context()).Debug("request coalesced", "wait", time.Since(start))
+		if f.resp == nil {
+			m.SendError(w, r, &Error{Status: http.StatusServiceUnavailable, Message: "coalesced request failed"})
+			return nil, false
+		}
+		writeStored(w, f.resp)
+		return nil, false
+	}
+	f := &flight{done: make(chan struct{})}
+	c.inflight[key] = f
+	c.mu.Unlock()
+	if rec.capture == nil {
+		rec.capture = &bytes.Buffer{}
+	}
+	return func() {
+		if rec.written {
+			// followers keep their own request ID, and cookies
+			// belong to the leader's client alone
+			header := w.Header().Clone()
+			header.Del("Set-Cookie")
+			for _, h := range m.RequestIDHeaders {
+				header.Del(h)
+			}
+			f.resp = &StoredResponse{
+				Status: rec.status,
+				Header: header,
+				Body:   bytes.Clone(rec.capture.Bytes()),
+			}
+		}
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(f.done)
+	}, true
+}
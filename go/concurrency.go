@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// acquireSlot counts the request against MaxConcurrent, returning false
+// when the server is already full. Paths in ConcurrencyExempt, such as
+// health checks, are neither limited nor counted.
+func (m *Manager) acquireSlot(r *http.Request) (release func(), ok bool) {
+	if m.MaxConcurrent <= 0 || slices.Contains(m.ConcurrencyExempt, r.URL.Path) {
+		return func() {}, true
+	}
+	if atomic.AddInt64(&m.inFlight, 1) > int64(m.MaxConcurrent) {
+		atomic.AddInt64(&m.inFlight, -1)
+		return nil, false
+	}
+	return func() { atomic.AddInt64(&m.inFlight, -1) }, true
+}
+
+// InFlight returns how many requests are currently counted against
+// MaxConcurrent.
+func (m *Manager) InFlight() int64 {
+	return atomic.LoadInt64(&m.inFlight)
+}
+
+func (m *Manager) overloaded() *Error {
+	retry := m.ConcurrencyRetryAfter
+	if retry <= 0 {
+		retry = time.Second
+	}
+	return &Error{
+		Status:     http.StatusServiceUnavailable,
+		Message:    "server is at capacity",
+		Retryable:  true,
+		RetryAfter: retry,
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+// csvColumns lists the columns of struct type t. Columns are named by the
+// csv tag, falling back to the json tag and then the field name; "-" omits
+// a field. Nested structs are flattened into "parent.child" columns; fields
+// leading back to a struct being flattened are omitted, since they would
+// nest without end.
+func csvColumns(t reflect.Type, index []int, prefix string, visiting map[reflect.Type]bool) []csvColumn {
+	if visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	var cols []csvColumn
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag, ok := f.Tag.Lookup("csv")
+		if !ok {
+			tag = f.Tag.Get("json")
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		idx := append(append([]int(nil), index...), i)
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		nested := ft.Kind() == reflect.Struct && !reflect.PointerTo(ft).Implements(textMarshalerType)
+		if nested && f.Anonymous && name == "" {
+			cols = append(cols, csvColumns(ft, idx, prefix, visiting)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if nested {
+			cols = append(cols, csvColumns(ft, idx, prefix+name+".", visiting)...)
+			continue
+		}
+		cols = append(cols, csvColumn{name: prefix + name, index: idx})
+	}
+	return cols
+}
+
+func csvField(v reflect.Value, index []int) string {
+	for _, x := range index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return ""
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		if err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// EncodeCSV writes a slice of structs as CSV with a header row, which is
+// written even when the slice is empty. Other values are ErrNotEncodable.
+func EncodeCSV(w io.Writer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return ErrNotEncodable
+	}
+	et := rv.Type().Elem()
+	if et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+	if et.Kind() != reflect.Struct {
+		return ErrNotEncodable
+	}
+	cols := csvColumns(et, nil, "", map[reflect.Type]bool{})
+	cw := csv.NewWriter(w)
+	record := make([]string, len(cols))
+	for i, col := range cols {
+		record[i] = col.name
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	for i := 0; i < rv.Len(); i++ {
+		for j, col := range cols {
+			record[j] = csvField(rv.Index(i), col.index)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,33 @@
+package main
+
+import "net/http"
+
+// DeleteResponse is how a successful DELETE is answered.
+type DeleteResponse int
+
+const (
+	// DeleteEcho writes the handler's output, usually the deleted
+	// resource, as for any other method. It is the default.
+	DeleteEcho DeleteResponse = iota + 1
+	// DeleteNoContent answers 204 with no body, discarding any output.
+	DeleteNoContent
+)
+
+// WithDeleteResponse overrides Manager.DeleteResponse for one handler, so a
+// handler returning the deleted resource serves both client styles.
+func WithDeleteResponse(mode DeleteResponse) HandlerOption {
+	return func(a *apiFunc) {
+		a.deleteResponse = mode
+	}
+}
+
+func (m *Manager) noContent(r *http.Request, af *apiFunc) bool {
+	if r.Method != http.MethodDelete {
+		return false
+	}
+	mode := af.deleteResponse
+	if mode == 0 {
+		mode = m.DeleteResponse
+	}
+	return mode == DeleteNoContent
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+type deprecation struct {
+	sunset time.Time
+	link   string
+}
+
+// WithDeprecation marks a handler deprecated. Every response carries
+// Deprecation: true, a Sunset header when sunset is set, and a Link to link,
+// usually migration docs, when it is not empty. Manager.LogDeprecated also
+// logs each call to measure remaining usage.
+func WithDeprecation(sunset time.Time, link string) HandlerOption {
+	return func(a *apiFunc) {
+		a.deprecation = &deprecation{sunset: sunset, link: link}
+	}
+}
+
+func (m *Manager) markDeprecated(w http.ResponseWriter, r *http.Request, d *deprecation) {
+	w.Header().Set("Deprecation", "true")
+	if !d.sunset.IsZero() {
+		w.Header().Set("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.link != "" {
+		w.Header().Add("Link", "<"+d.link+`>; rel="deprecation"`)
+	}
+	if m.LogDeprecated {
+		LoggerFromContext(r.Context()).Info("deprecated endpoint called",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"sunset", d.sunset,
+			"user_agent", r.UserAgent(),
+		)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+var errTooDeep = errors.New("maximum JSON nesting depth exceeded")
+
+// depthReader fails the read once the JSON passing through it nests deeper
+// than max, so the decoder never recurses on abusive input.
+type depthReader struct {
+	r        io.Reader
+	max      int
+	depth    int
+	inString bool
+	escaped  bool
+}
+
+func (d *depthReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	for i, c := range p[:n] {
+		switch {
+		case d.escaped:
+			d.escaped = false
+		case d.inString:
+			switch c {
+			case '\\':
+				d.escaped = true
+			case '"':
+				d.inString = false
+			}
+		case c == '"':
+			d.inString = true
+		case c == '{' || c == '[':
+			d.depth++
+			if d.depth > d.max {
+				return i, errTooDeep
+			}
+		case c == '}' || c == ']':
+			d.depth--
+		}
+	}
+	return n, err
+}
+
+func (m *Manager) limitDepth(r io.Reader) io.Reader {
+	if m.MaxDepth <= 0 {
+		return r
+	}
+	return &depthReader{r: r, max: m.MaxDepth}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+type DryRunMode int
+
+const (
+	// DryRunEcho validates the input and responds with it without calling
+	// the handler.
+	DryRunEcho DryRunMode = iota + 1
+	// DryRunDelegate calls the handler, which checks DryRun(ctx) itself.
+	DryRunDelegate
+)
+
+type dryRunKey struct{}
+
+// WithDryRun lets clients request a dry run with ?dryRun=true or a
+// "Dry-Run: true" header.
+func WithDryRun(mode DryRunMode) HandlerOption {
+	return func(a *apiFunc) {
+		a.dryRun = mode
+	}
+}
+
+// DryRun reports whether the client asked for the request to be validated
+// without taking effect.
+func DryRun(ctx context.Context) bool {
+	dry, _ := ctx.Value(dryRunKey{}).(bool)
+	return dry
+}
+
+func dryRunRequested(r *http.Request) bool {
+	v := r.URL.Query().Get("dryRun")
+	if v == "" {
+		v = r.Header.Get("Dry-Run")
+	}
+	dry, _ := strconv.ParseBool(v)
+	return dry
+}
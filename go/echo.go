@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const maxEchoBody = 64 << 10
+
+// credentialHeaders are echoed masked, showing only that they were sent.
+var credentialHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"X-Api-Key",
+	"X-On-Behalf-Of",
+}
+
+func (m *Manager) echoHeaders(h http.Header) http.Header {
+	masked := append([]string{m.MaintenanceBypassHeader, defaultBypassHeader}, credentialHeaders...)
+	resolver := m.AccountIDResolver
+	if c, ok := resolver.(*CachedAccountIDResolver); ok {
+		resolver = c.Resolver
+	}
+	if o, ok := resolver.(*OnBehalfOf); ok {
+		masked = append(masked, o.header())
+	}
+	h = h.Clone()
+	for _, name := range masked {
+		if vs := h.Values(name); len(vs) > 0 {
+			h[http.CanonicalHeaderKey(name)] = []string{redacted}
+		}
+	}
+	return h
+}
+
+type echoInfo struct {
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Route         string            `json:"route,omitempty"`
+	Query         url.Values        `json:"query"`
+	Headers       http.Header       `json:"headers"`
+	Cookies       map[string]string `json:"cookies"`
+	ContentType   string            `json:"content_type"`
+	AccountID     int               `json:"account_id"`
+	RequestID     string            `json:"request_id,omitempty"`
+	Body          string            `json:"body"`
+	BodyTruncated bool              `json:"body_truncated,omitempty"`
+}
+
+// EchoHandler responds with what the harness sees of the request, to help
+// integrators debug their calls. Credential headers and cookie values are
+// masked, since the response may be logged along the way. It answers 404
+// unless EnableEcho is set.
+func (m *Manager) EchoHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !m.EnableEcho {
+			http.NotFound(w, r)
+			return
+		}
+		ctx := m.withRequestID(m.requestContext(r), w, r)
+		r = r.WithContext(ctx)
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxEchoBody+1))
+		if err != nil {
+			m.SendError(w, r, bodyError(err))
+			return
+		}
+		accountID, err := m.accountID(r)
+		if err != nil {
+			m.SendError(w, r, err)
+			return
+		}
+		info := echoInfo{
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			Route:       RoutePatternFromContext(ctx),
+			Query:       r.URL.Query(),
+			Headers:     m.echoHeaders(r.Header),
+			Cookies:     map[string]string{},
+			ContentType: r.Header.Get("Content-Type"),
+			AccountID:   accountID,
+			RequestID:   RequestIDFromContext(ctx),
+		}
+		if len(body) > maxEchoBody {
+			body, info.BodyTruncated = body[:maxEchoBody], true
+		}
+		info.Body = string(body)
+		for _, c := range r.Cookies() {
+			info.Cookies[c.Name] = redacted
+		}
+		m.sendJSON(w, r, http.StatusOK, info)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// ErrorMetadata selects the standard fields added to error bodies, including
+// problem documents as extension members; success responses are unaffected. TraceID returns the trace_id for a request,
+// Timestamp adds the time of the error, and DocsURL maps an error code to
+// its documentation, omitted when it returns "" or the error has no code.
+type ErrorMetadata struct {
+	TraceID   func(ctx context.Context) string
+	Timestamp bool
+	DocsURL   func(code string) string
+}
+
+func (em *ErrorMetadata) apply(r *http.Request, body *errorBody) {
+	if em.TraceID != nil {
+		body.TraceID = em.TraceID(r.Context())
+	}
+	if em.Timestamp {
+		now := time.Now().UTC()
+		body.Timestamp = &now
+	}
+	if em.DocsURL != nil && body.Code != "" {
+		body.DocsURL = em.DocsURL(body.Code)
+	}
+}
+
+// applyProblem adds the same fields to a problem as extension members,
+// keeping any the problem sets itself. A "code" extension selects DocsURL.
+// p is copied rather than modified, as problems are often shared values.
+func (em *ErrorMetadata) applyProblem(r *http.Request, p *Problem) *Problem {
+	var body errorBody
+	body.Code, _ = p.Extensions["code"].(string)
+	em.apply(r, &body)
+	add := map[string]interface{}{}
+	if body.TraceID != "" {
+		add["trace_id"] = body.TraceID
+	}
+	if body.Timestamp != nil {
+		add["timestamp"] = body.Timestamp
+	}
+	if body.DocsURL != "" {
+		add["docs_url"] = body.DocsURL
+	}
+	if len(add) == 0 {
+		return p
+	}
+	cp := *p
+	cp.Extensions = make(map[string]interface{}, len(p.Extensions)+len(add))
+	for k, v := range add {
+		cp.Extensions[k] = v
+	}
+	for k, v := range p.Extensions {
+		cp.Extensions[k] = v
+	}
+	return &cp
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// fieldTree is a parsed ?fields= selector. A nil subtree keeps the whole
+// value below that key.
+type fieldTree map[string]fieldTree
+
+type fieldsKey struct{}
+
+// WithFieldSelection lets clients trim the response to the JSON keys named
+// in ?fields=id,name,address.city. Unknown names are ignored.
+func WithFieldSelection() HandlerOption {
+	return func(a *apiFunc) {
+		a.fieldSelection = true
+	}
+}
+
+func parseFields(s string) fieldTree {
+	tree := fieldTree{}
+	for _, path := range strings.Split(s, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		node := tree
+		parts := strings.Split(path, ".")
+		for i, part := range parts {
+			sub, seen := node[part]
+			if i == len(parts)-1 {
+				node[part] = nil
+				break
+			}
+			if seen && sub == nil {
+				// an ancestor already selects everything below
+				break
+			}
+			if sub == nil {
+				sub = fieldTree{}
+				node[part] = sub
+			}
+			node = sub
+		}
+	}
+	return tree
+}
+
+func withFieldSelection(ctx context.Context, r *http.Request) context.Context {
+	fields := r.URL.Query().Get("fields")
+	if fields == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, fieldsKey{}, parseFields(fields))
+}
+
+func filterFields(doc interface{}, tree fieldTree) interface{} {
+	if tree == nil {
+		return doc
+	}
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		for k, v := range doc {
+			sub, ok := tree[k]
+			if !ok {
+				delete(doc, k)
+				continue
+			}
+			doc[k] = filterFields(v, sub)
+		}
+	case []interface{}:
+		for i, v := range doc {
+			doc[i] = filterFields(v, tree)
+		}
+	}
+	return doc
+}
+
+// selectFields applies the request's field selection to v by round-tripping
+// it through the codec. v is returned unchanged when nothing was selected.
+func (m *Manager) selectFields(r *http.Request, v interface{}) interface{} {
+	tree, ok := r.Context().Value(fieldsKey{}).(fieldTree)
+	if !ok {
+		return v
+	}
+	var buf bytes.Buffer
+	if err := m.Codec.NewEncoder(&buf).Encode(v); err != nil {
+		return v
+	}
+	var doc interface{}
+	decoder := json.NewDecoder(&buf)
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return v
+	}
+	return filterFields(doc, tree)
+}
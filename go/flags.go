@@ -0,0 +1,31 @@
+package main
+
+import "context"
+
+// FlagSource evaluates feature flags, e.g. an unleash client.
+type FlagSource interface {
+	IsEnabled(name string) bool
+}
+
+// Flags is the set of feature flag values captured when a request started.
+type Flags map[string]bool
+
+type flagsKey struct{}
+
+func (m *Manager) snapshotFlags(ctx context.Context) context.Context {
+	if m.FlagSource == nil || len(m.SnapshotFlags) == 0 {
+		return ctx
+	}
+	flags := make(Flags, len(m.SnapshotFlags))
+	for _, name := range m.SnapshotFlags {
+		flags[name] = m.FlagSource.IsEnabled(name)
+	}
+	return context.WithValue(ctx, flagsKey{}, flags)
+}
+
+// FlagsFromContext returns the flags snapshotted for the request. Flags that
+// were not snapshotted read as disabled.
+func FlagsFromContext(ctx context.Context) Flags {
+	flags, _ := ctx.Value(flagsKey{}).(Flags)
+	return flags
+}
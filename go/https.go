@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HSTS configures the HTTPS middleware. TrustForwardedProto takes the scheme
+// from X-Forwarded-Proto and must only be enabled behind a proxy that sets it.
+type HSTS struct {
+	MaxAge              time.Duration
+	IncludeSubDomains   bool
+	TrustForwardedProto bool
+}
+
+// HTTPS redirects plain HTTP requests to https and sets
+// Strict-Transport-Security on secure responses.
+func HTTPS(cfg HSTS) func(http.Handler) http.Handler {
+	hsts := "max-age=" + strconv.Itoa(int(cfg.MaxAge/time.Second))
+	if cfg.IncludeSubDomains {
+		hsts += "; includeSubDomains"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secure := r.TLS != nil
+			if !secure && cfg.TrustForwardedProto {
+				secure = r.Header.Get("X-Forwarded-Proto") == "https"
+			}
+			if !secure {
+				status := http.StatusMovedPermanently
+				if r.Method != http.MethodGet && r.Method != http.MethodHead {
+					status = http.StatusPermanentRedirect
+				}
+				http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), status)
+				return
+			}
+			w.Header().Set("Strict-Transport-Security", hsts)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// acceptedLanguages returns the language tags of an Accept-Language header
+// ordered by preference.
+func acceptedLanguages(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			langs = append(langs, lang{tag: strings.ToLower(tag), q: q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
+
+// localize resolves the display message for code in the client's preferred
+// language, trying each tag and then its base language.
+func (m *Manager) localize(r *http.Request, code string) (string, bool) {
+	if m.Localize == nil || code == "" {
+		return "", false
+	}
+	for _, tag := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+		if msg, ok := m.Localize(code, tag); ok {
+			return msg, true
+		}
+		if base, _, ok := strings.Cut(tag, "-"); ok {
+			if msg, ok := m.Localize(code, base); ok {
+				return msg, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrIdempotencyInProgress = errors.New("request with this idempotency key is in progress")
+
+// StoredResponse is a response kept for replay. Fingerprint identifies the
+// request that produced it, so stores must persist it along with the rest.
+type StoredResponse struct {
+	Status      int
+	Header      http.Header
+	Body        []byte
+	Fingerprint string
+}
+
+// IdempotencyStore records responses by idempotency key. Reserve claims a key
+// for the first request, returning (nil, nil); a later request gets the
+// committed response, or ErrIdempotencyInProgress while the first is still
+// running. Release gives up a reservation without storing a response.
+// Reservations and responses both expire after ttl.
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, key string, ttl time.Duration) (*StoredResponse, error)
+	Commit(ctx context.Context, key string, resp *StoredResponse, ttl time.Duration) error
+	Release(ctx context.Context, key string) error
+}
+
+func writeStored(w http.ResponseWriter, resp *StoredResponse) {
+	for k, vs := range resp.Header {
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body)
+}
+
+type idempotencyEntry struct {
+	resp    *StoredResponse
+	expires time.Time
+}
+
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: map[string]*idempotencyEntry{}}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(_ context.Context, key string, ttl time.Duration) (*StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		if e.resp == nil {
+			return nil, ErrIdempotencyInProgress
+		}
+		return e.resp, nil
+	}
+	s.entries[key] = &idempotencyEntry{expires: now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Commit(_ context.Context, key string, resp *StoredResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = &idempotencyEntry{resp: resp, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+type idempotency struct {
+	store IdempotencyStore
+	ttl   time.Duration
+}
+
+// WithIdempotency replays the stored response for requests repeating an
+// Idempotency-Key header within ttl. Keys are scoped to the caller's
+// account, so the account is resolved for these requests, and a key reused
+// with a different query, body or bound header or cookie gets 422 instead of
+// a replay. Failed (5xx) responses are not stored, so the client may retry
+// them.
+func WithIdempotency(store IdempotencyStore, ttl time.Duration) HandlerOption {
+	return func(a *apiFunc) {
+		a.idempotency = &idempotency{store: store, ttl: ttl}
+	}
+}
+
+// reserveIdempotent returns false when the response has already been
+// written, either as a replay or an error. Otherwise finish must be called
+// once the handler has responded.
+func (m *Manager) reserveIdempotent(w http.ResponseWriter, r *http.Request, af *apiFunc, rec *responseRecorder, key string, accountID int, readBody *deadlineBody) (finish func(), ok bool) {
+	ctx := r.Context()
+	idem := af.idempotency
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		m.SendError(w, r, readError(w, readBody, bodyError(err)))
+		return nil, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+	// everything the handler is given beyond the path and account
+	sum := sha256.Sum256(append([]byte(r.URL.RawQuery+af.boundHeaders(r)+"\n"), raw...))
+	fingerprint := hex.EncodeToString(sum[:])
+	key = r.Method + " " + r.URL.Path + " " + strconv.Itoa(accountID) + " " + key
+	resp, err := idem.store.Reserve(ctx, key, idem.ttl)
+	if err == ErrIdempotencyInProgress {
+		err = &Error{Status: http.StatusConflict, Message: err.Error()}
+	}
+	if err != nil {
+		m.SendError(w, r, err)
+		return nil, false
+	}
+	if resp != nil && resp.Fingerprint != fingerprint {
+		m.SendError(w, r, &Error{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    "idempotency_key_reused",
+			Message: "Idempotency-Key was used for a different request",
+		})
+		return nil, false
+	}
+	if resp != nil {
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeStored(w, resp)
+		return nil, false
+	}
+	if rec.capture == nil {
+		rec.capture = &bytes.Buffer{}
+	}
+	return func() {
+		log := LoggerFromContext(ctx)
+		if !rec.written || rec.status >= 500 {
+			if err := idem.store.Release(ctx, key); err != nil {
+				log.Error("error releasing idempotency key", "error", err)
+			}
+			return
+		}
+		// a replay answers a new request with its own ID
+		header := w.Header().Clone()
+		for _, h := range m.RequestIDHeaders {
+			header.Del(h)
+		}
+		resp := &StoredResponse{
+			Status:      rec.status,
+			Header:      header,
+			Body:        rec.capture.Bytes(),
+			Fingerprint: fingerprint,
+		}
+		if err := idem.store.Commit(ctx, key, resp, idem.ttl); err != nil {
+			log.Error("error storing idempotent response", "error", err)
+		}
+	}, true
+}
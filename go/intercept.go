@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+)
+
+type inputInterceptor struct {
+	f      func(ctx context.Context, in interface{}) error
+	status int
+}
+
+// WithInputInterceptor runs f on the decoded, bound and validated input
+// before the handler sees it. in is a pointer to the input, which f may
+// modify, or a nil pointer for an empty body on a pointer input. An *Error
+// or *ValidationError from f is sent as is; other errors are sent with
+// status. Interceptors run in the order they are given.
+func WithInputInterceptor(f func(ctx context.Context, in interface{}) error, status int) HandlerOption {
+	return func(a *apiFunc) {
+		a.interceptors = append(a.interceptors, inputInterceptor{f: f, status: status})
+	}
+}
+
+func (af *apiFunc) intercept(ctx context.Context, arg reflect.Value) error {
+	for _, ic := range af.interceptors {
+		err := ic.f(ctx, arg.Interface())
+		if err == nil {
+			continue
+		}
+		switch e := unwrap(err).(type) {
+		case *Error:
+			return e
+		case *ValidationError:
+			return e
+		}
+		status := ic.status
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+		return &Error{Status: status, Message: err.Error()}
+	}
+	return nil
+}
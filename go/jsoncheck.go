@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	representerType   = reflect.TypeOf((*Representer)(nil)).Elem()
+
+	// lazyOutputs build their body at request time and can't be checked.
+	lazyOutputs = map[reflect.Type]bool{
+		reflect.TypeOf(Conditional{}):  true,
+		reflect.TypeOf(&Conditional{}): true,
+		reflect.TypeOf(Stream{}):       true,
+		reflect.TypeOf(&Stream{}):      true,
+		reflect.TypeOf(StreamArray{}):  true,
+		reflect.TypeOf(&StreamArray{}): true,
+		reflect.TypeOf(Multipart{}):    true,
+		reflect.TypeOf(&Multipart{}):   true,
+	}
+)
+
+// WithOutputCheck makes registration fail when the output type contains
+// values encoding/json cannot marshal, such as channels or funcs.
+func WithOutputCheck() HandlerOption {
+	return func(a *apiFunc) {
+		a.checkOutput = true
+	}
+}
+
+func implementsMarshaler(t reflect.Type) bool {
+	for _, mt := range []reflect.Type{jsonMarshalerType, textMarshalerType} {
+		if t.Implements(mt) || reflect.PointerTo(t).Implements(mt) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkMarshalable(t reflect.Type, path string, seen map[reflect.Type]bool) error {
+	if seen[t] || lazyOutputs[t] || implementsMarshaler(t) || t.Implements(representerType) {
+		return nil
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return fmt.Errorf("%s: cannot marshal %s", path, t)
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return checkMarshalable(t.Elem(), path+"[]", seen)
+	case reflect.Map:
+		switch k := t.Key(); {
+		case k.Kind() == reflect.String, k.Implements(textMarshalerType):
+		case k.Kind() >= reflect.Int && k.Kind() <= reflect.Uintptr:
+		default:
+			return fmt.Errorf("%s: cannot marshal map key %s", path, k)
+		}
+		return checkMarshalable(t.Elem(), path+"[]", seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() && !f.Anonymous {
+				continue
+			}
+			if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name == "-" {
+				continue
+			}
+			if err := checkMarshalable(f.Type, path+"."+f.Name, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type jsonField struct {
+	path  string
+	depth int
+}
+
+func collectJSONNames(t reflect.Type, depth int, prefix string, visiting map[reflect.Type]bool, names map[string][]jsonField) {
+	visiting[t] = true
+	defer delete(visiting, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if !visiting[ft] {
+					collectJSONNames(ft, depth+1, prefix+f.Name+".", visiting, names)
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names[name] = append(names[name], jsonField{path: prefix + f.Name, depth: depth})
+	}
+}
+
+// checkJSONNames reports fields of t that map to the same JSON key at the
+// same embedding depth, which encoding/json resolves silently.
+func checkJSONNames(t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	names := map[string][]jsonField{}
+	collectJSONNames(t, 0, t.Name()+".", map[reflect.Type]bool{}, names)
+	for name, fields := range names {
+		var shallowest []string
+		depth := -1
+		for _, f := range fields {
+			switch {
+			case depth == -1 || f.depth < depth:
+				depth, shallowest = f.depth, []string{f.path}
+			case f.depth == depth:
+				shallowest = append(shallowest, f.path)
+			}
+		}
+		if len(shallowest) > 1 {
+			return fmt.Errorf("fields %s map to the same JSON key %q", strings.Join(shallowest, " and "), name)
+		}
+	}
+	return nil
+}
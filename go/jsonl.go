@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+)
+
+const jsonLinesCT = "application/jsonl"
+
+// writeJSONLines streams a slice, array or receive channel as JSON Lines
+// when the client prefers application/jsonl (or application/x-ndjson) over
+// JSON, reporting whether it did. Lines are encoded one at a time and
+// flushed every Manager.JSONLinesFlushEvery lines, and whenever a channel
+// has nothing ready.
+func (m *Manager) writeJSONLines(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Header.Get("Accept") == "" {
+		return false
+	}
+	items := reflect.ValueOf(v)
+	switch items.Kind() {
+	case reflect.Slice, reflect.Array:
+	case reflect.Chan:
+		if items.Type().ChanDir()&reflect.RecvDir == 0 {
+			return false
+		}
+	default:
+		return false
+	}
+	mt := negotiate(r, "application/json", jsonLinesCT, "application/x-ndjson")
+	if mt != jsonLinesCT && mt != "application/x-ndjson" {
+		return false
+	}
+	w.Header().Set("Content-Type", mt)
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	every := m.JSONLinesFlushEvery
+	if every <= 0 {
+		every = 1
+	}
+	var buf bytes.Buffer
+	encoder := m.Codec.NewEncoder(&buf)
+	unflushed := 0
+	write := func(item reflect.Value) bool {
+		buf.Reset()
+		if err := encoder.Encode(item.Interface()); err != nil {
+			LoggerFromContext(r.Context()).Error("error encoding response", "error", err)
+			return false
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return false
+		}
+		if unflushed++; unflushed >= every {
+			_ = rc.Flush()
+			unflushed = 0
+		}
+		return true
+	}
+	if items.Kind() != reflect.Chan {
+		for i := 0; i < items.Len(); i++ {
+			if !write(items.Index(i)) {
+				return true
+			}
+		}
+		return true
+	}
+	for {
+		item, ok := items.TryRecv()
+		if !ok && item.IsValid() {
+			return true
+		}
+		if !item.IsValid() {
+			if unflushed > 0 {
+				_ = rc.Flush()
+				unflushed = 0
+			}
+			if item, ok = items.Recv(); !ok {
+				return true
+			}
+		}
+		if !write(item) {
+			drain(items)
+			return true
+		}
+	}
+}
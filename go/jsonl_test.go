@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+type exportRow struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestJSONLinesNegotiation(t *testing.T) {
+	rows := []exportRow{{1, "a"}, {2, "b"}}
+	for _, tc := range []struct {
+		accept, wantType, wantBody string
+	}{
+		{"application/jsonl", "application/jsonl", "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n"},
+		{"application/x-ndjson", "application/x-ndjson", "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n"},
+		{"text/csv", "text/csv; charset=utf-8", "id,name\n1,a\n2,b\n"},
+		{"application/json", jsonCT, "[{\"id\":1,\"name\":\"a\"},{\"id\":2,\"name\":\"b\"}]\n"},
+	} {
+		t.Run(tc.accept, func(t *testing.T) {
+			m := NewManager(slog.Default())
+			m.Route("GET /export", func(ctx context.Context) ([]exportRow, error) { return rows, nil })
+			w := serve(m, "GET", "/export", "", "Accept", tc.accept)
+			if got := w.Header().Get("Content-Type"); got != tc.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tc.wantType)
+			}
+			if got := w.Body.String(); got != tc.wantBody {
+				t.Errorf("body = %q, want %q", got, tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestJSONLinesNotForOtherTypes(t *testing.T) {
+	m := NewManager(slog.Default())
+	m.Route("GET /bytes", func(ctx context.Context) ([]byte, error) { return []byte("hi"), nil })
+	w := serve(m, "GET", "/bytes", "", "Accept", "text/html")
+	if got := w.Header().Get("Content-Type"); got == "" || got == jsonLinesCT {
+		t.Errorf("Content-Type = %q, want the JSON fallback", got)
+	}
+}
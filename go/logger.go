@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type loggerKey struct{}
+
+func (m *Manager) requestLogger(ctx context.Context, r *http.Request) *slog.Logger {
+	log := m.log
+	if route := RoutePatternFromContext(ctx); route != "" {
+		log = log.With("route", route)
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		log = log.With("request_id", id)
+	}
+	return log.With(retryFields(r, m.RetryAttemptHeader)...)
+}
+
+// maxLoggedKey bounds client supplied values copied into every log entry.
+const maxLoggedKey = 128
+
+// retryFields correlates a client's retries: the Idempotency-Key shared by
+// every attempt, and the attempt number the client reports in header,
+// X-Retry-Attempt by default.
+func retryFields(r *http.Request, header string) []interface{} {
+	var fields []interface{}
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		if len(key) > maxLoggedKey {
+			key = key[:maxLoggedKey]
+		}
+		fields = append(fields, "idempotency_key", key)
+	}
+	if header == "" {
+		header = "X-Retry-Attempt"
+	}
+	if attempt, err := strconv.Atoi(r.Header.Get(header)); err == nil && attempt >= 0 {
+		fields = append(fields, "attempt", attempt)
+	}
+	return fields
+}
+
+// LoggerFromContext returns the request logger installed by the Manager,
+// already carrying the request's correlation fields. Outside of a request it
+// returns slog.Default().
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return log
+	}
+	return slog.Default()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultBypassHeader = "X-Maintenance-Bypass"
+
+type maintenance struct {
+	on     atomic.Bool
+	mu     sync.RWMutex
+	bypass [][sha256.Size]byte
+}
+
+// SetMaintenance turns maintenance mode on or off. While it is on, handlers
+// answer 503 unless the request carries a bypass token.
+func (m *Manager) SetMaintenance(on bool) {
+	m.maintenance.on.Store(on)
+}
+
+// SetMaintenanceBypass replaces the tokens that let a request through
+// during maintenance in the MaintenanceBypassHeader header. Passing both the
+// old and new token while rotating keeps callers working; passing none
+// disables the bypass.
+func (m *Manager) SetMaintenanceBypass(tokens ...string) {
+	hashes := make([][sha256.Size]byte, 0, len(tokens))
+	for _, t := range tokens {
+		if t != "" {
+			hashes = append(hashes, sha256.Sum256([]byte(t)))
+		}
+	}
+	m.maintenance.mu.Lock()
+	m.maintenance.bypass = hashes
+	m.maintenance.mu.Unlock()
+}
+
+// inMaintenance reports whether r must be turned away. Tokens are compared
+// as hashes in constant time, and every configured one is checked, so
+// neither the length nor which token matched leaks through timing.
+func (m *Manager) inMaintenance(r *http.Request) bool {
+	if !m.maintenance.on.Load() {
+		return false
+	}
+	header := m.MaintenanceBypassHeader
+	if header == "" {
+		header = defaultBypassHeader
+	}
+	token := r.Header.Get(header)
+	if token == "" {
+		return true
+	}
+	sum := sha256.Sum256([]byte(token))
+	m.maintenance.mu.RLock()
+	defer m.maintenance.mu.RUnlock()
+	match := 0
+	for i := range m.maintenance.bypass {
+		match |= subtle.ConstantTimeCompare(sum[:], m.maintenance.bypass[i][:])
+	}
+	return match == 0
+}
+
+var errMaintenance = &Error{
+	Status:    http.StatusServiceUnavailable,
+	Code:      "maintenance",
+	Message:   "service is under maintenance",
+	Retryable: true,
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sync"
+)
+
+// Metrics receives measurements from the harness, typically forwarding
+// them to Prometheus histograms and gauges. Route is the matched pattern.
+type Metrics interface {
+	ObserveRequestSize(route string, bytes int64)
+	ObserveResponseSize(route string, bytes int64)
+	// ObserveCoalesced counts requests answered by another request's
+	// in-flight result.
+	ObserveCoalesced(route string)
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// requestBytes is the size of the body read, or its declared length when
+// the handler never read it.
+func requestBytes(r *http.Request, body *countingReader) int64 {
+	if body.n == 0 && r.ContentLength > 0 {
+		return r.ContentLength
+	}
+	return body.n
+}
+
+// sizeStats keeps a running mean and variance of a handler's request body
+// sizes using Welford's algorithm.
+type sizeStats struct {
+	mu    sync.Mutex
+	count int64
+	mean  float64
+	m2    float64
+}
+
+// minSizeSamples is how many requests a handler must see before its body
+// sizes are judged against the distribution.
+const minSizeSamples = 100
+
+// observe records n and returns how many standard deviations it lies from
+// the mean of the sizes seen before it, or 0 until there are enough samples.
+func (s *sizeStats) observe(n int64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x := float64(n)
+	var dev float64
+	if s.count >= minSizeSamples {
+		if sd := math.Sqrt(s.m2 / float64(s.count)); sd > 0 {
+			dev = math.Abs(x-s.mean) / sd
+		} else if x != s.mean {
+			dev = math.Inf(1)
+		}
+	}
+	s.count++
+	delta := x - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (x - s.mean)
+	return dev
+}
+
+func (m *Manager) observeSizes(r *http.Request, af *apiFunc, rec *responseRecorder, reqBytes int64) {
+	if m.Metrics != nil {
+		route := RoutePatternFromContext(r.Context())
+		m.Metrics.ObserveRequestSize(route, reqBytes)
+		m.Metrics.ObserveResponseSize(route, rec.bytes)
+	}
+	log := LoggerFromContext(r.Context())
+	if m.SoftRequestBytes > 0 && reqBytes > m.SoftRequestBytes {
+		log.Warn("large request body", "bytes", reqBytes, "threshold", m.SoftRequestBytes)
+	}
+	if m.BodySizeDeviation > 0 {
+		if dev := af.sizes.observe(reqBytes); dev > m.BodySizeDeviation {
+			log.Warn("unusual request body size", "bytes", reqBytes, "deviation", dev)
+		}
+	}
+	if m.SoftResponseBytes > 0 && rec.bytes > m.SoftResponseBytes {
+		log.Warn("large response body", "bytes", rec.bytes, "threshold", m.SoftResponseBytes)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+)
+
+// Multipart writes its parts as a multipart/mixed body.
+type Multipart struct {
+	Parts []Part
+}
+
+// Part is one section of a Multipart response. A Body of []byte, string or
+// io.Reader is written as is, with ContentType defaulting to
+// application/octet-stream; any other value is encoded as JSON. Header
+// adds further part headers such as Content-Disposition. io.Reader bodies
+// are closed afterwards if they implement io.Closer.
+type Part struct {
+	ContentType string
+	Header      http.Header
+	Body        interface{}
+}
+
+func (m *Manager) writeMultipart(w http.ResponseWriter, r *http.Request, mp *Multipart) {
+	defer func() {
+		for _, p := range mp.Parts {
+			if c, ok := p.Body.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}()
+	parts := make([][]byte, len(mp.Parts))
+	for i, p := range mp.Parts {
+		if _, ok := p.Body.(io.Reader); ok {
+			continue
+		}
+		b, err := m.partBody(p.Body)
+		if err != nil {
+			LoggerFromContext(r.Context()).Error("error encoding response", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		parts[i] = b
+	}
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	for i, p := range mp.Parts {
+		header := textproto.MIMEHeader{}
+		for k, vs := range p.Header {
+			header[textproto.CanonicalMIMEHeaderKey(k)] = vs
+		}
+		header.Set("Content-Type", partContentType(p))
+		pw, err := mw.CreatePart(header)
+		if err == nil {
+			if rd, ok := p.Body.(io.Reader); ok {
+				_, err = io.Copy(pw, rd)
+			} else {
+				_, err = pw.Write(parts[i])
+			}
+		}
+		if err != nil {
+			LoggerFromContext(r.Context()).Error("error streaming response", "error", err)
+			return
+		}
+	}
+	if err := mw.Close(); err != nil {
+		LoggerFromContext(r.Context()).Error("error streaming response", "error", err)
+	}
+}
+
+func (m *Manager) partBody(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	var buf bytes.Buffer
+	if err := m.Codec.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+func partContentType(p Part) string {
+	if p.ContentType != "" {
+		return p.ContentType
+	}
+	switch p.Body.(type) {
+	case []byte, string, io.Reader:
+		return "application/octet-stream"
+	}
+	return jsonCT
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		typ, subtype, _ := strings.Cut(mt, "/")
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q})
+	}
+	return ranges
+}
+
+// quality returns the weight the client gives to offer, taken from the most
+// specific matching range, or -1 when no range matches.
+func quality(ranges []mediaRange, offer string) float64 {
+	typ, subtype, _ := strings.Cut(offer, "/")
+	q, specificity := -1.0, -1
+	for _, mr := range ranges {
+		s := -1
+		switch {
+		case mr.typ == typ && mr.subtype == subtype:
+			s = 2
+		case mr.typ == typ && mr.subtype == "*":
+			s = 1
+		case mr.typ == "*" && mr.subtype == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = mr.q, s
+		}
+	}
+	return q
+}
+
+// negotiate picks the offered media type the client prefers, favouring
+// earlier offers on ties. It returns the first offer when the request has
+// no Accept header and "" when nothing offered is acceptable.
+func negotiate(r *http.Request, offers ...string) string {
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return offers[0]
+	}
+	ranges := parseAccept(header)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(ranges, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// ErrNotEncodable is returned by a ResponseEncoder for values it has no
+// representation for; the response then falls back to JSON.
+var ErrNotEncodable = errors.New("value cannot be encoded in the requested format")
+
+type ResponseEncoder func(w io.Writer, v interface{}) error
+
+type registeredEncoder struct {
+	mediaType string
+	encode    ResponseEncoder
+}
+
+// RegisterEncoder makes mediaType available to clients through Accept
+// negotiation. JSON remains the default and wins ties.
+func (m *Manager) RegisterEncoder(mediaType string, enc ResponseEncoder) {
+	m.encoders = append(m.encoders, registeredEncoder{mediaType: mediaType, encode: enc})
+}
+
+// writeNegotiated writes v with a registered encoder when the client prefers
+// one over JSON, reporting whether it did.
+func (m *Manager) writeNegotiated(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if len(m.encoders) == 0 || r.Header.Get("Accept") == "" {
+		return false
+	}
+	offers := []string{"application/json"}
+	for _, enc := range m.encoders {
+		offers = append(offers, enc.mediaType)
+	}
+	mt := negotiate(r, offers...)
+	for _, enc := range m.encoders {
+		if enc.mediaType != mt {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := enc.encode(&buf, v); err == ErrNotEncodable {
+			return false
+		} else if err != nil {
+			m.SendError(w, r, err)
+			return true
+		}
+		if strings.HasPrefix(mt, "text/") {
+			mt += "; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", mt)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(buf.Bytes())
+		return true
+	}
+	return false
+}
+
+type RequestDecoder func(r io.Reader, v interface{}) error
+
+type registeredDecoder struct {
+	mediaType string
+	decode    RequestDecoder
+}
+
+// RegisterDecoder decodes request bodies of mediaType with dec instead of
+// JSON, e.g. DecodeXML for application/xml. dec returns io.EOF for an empty
+// body. Handlers with a schema, null checks, or bound fields whose
+// precedence, defaults or required checks depend on the body only accept
+// JSON and answer other registered types with 415.
+func (m *Manager) RegisterDecoder(mediaType string, dec RequestDecoder) {
+	m.decoders = append(m.decoders, registeredDecoder{mediaType: mediaType, decode: dec})
+}
+
+func (m *Manager) requestDecoder(r *http.Request) RequestDecoder {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	for _, dec := range m.decoders {
+		if strings.EqualFold(dec.mediaType, mt) {
+			return dec.decode
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// normalizers are applied in tag order by `normalize:"trim,lower"` on
+// string fields, and on each element of []string fields. collapse-spaces
+// replaces each run of whitespace with one space and trims the ends.
+var normalizers = map[string]func(string) string{
+	"trim":  strings.TrimSpace,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"collapse-spaces": func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	},
+}
+
+// normalizes reports whether t has normalize tags, rejecting unknown
+// normalizers and tags on fields that aren't strings.
+func normalizes(t reflect.Type, visiting map[reflect.Type]bool) (bool, error) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || visiting[t] {
+		return false, nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	found := false
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag, ok := f.Tag.Lookup("normalize")
+		if !ok {
+			nested, err := normalizes(f.Type, visiting)
+			if err != nil {
+				return false, err
+			}
+			found = found || nested
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.String {
+			return false, fmt.Errorf("field %s: normalize requires a string or []string", f.Name)
+		}
+		for _, name := range strings.Split(tag, ",") {
+			if _, ok := normalizers[strings.TrimSpace(name)]; !ok {
+				return false, fmt.Errorf("field %s: unknown normalizer %q", f.Name, name)
+			}
+		}
+		found = true
+	}
+	return found, nil
+}
+
+// normalizeValue applies the normalize tags found in v, which must be
+// addressable, descending into nested structs, pointers, slices and maps.
+func normalizeValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			normalizeValue(v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			normalizeValue(v.Index(i))
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			normalizeValue(elem)
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fv := v.Field(i)
+			if !fv.CanSet() && !f.Anonymous {
+				continue
+			}
+			tag, ok := f.Tag.Lookup("normalize")
+			if !ok {
+				normalizeValue(fv)
+				continue
+			}
+			applyNormalizers(fv, strings.Split(tag, ","))
+		}
+	}
+}
+
+func applyNormalizers(v reflect.Value, names []string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			applyNormalizers(v.Elem(), names)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			applyNormalizers(v.Index(i), names)
+		}
+	case reflect.String:
+		s := v.String()
+		for _, name := range names {
+			s = normalizers[strings.TrimSpace(name)](s)
+		}
+		v.SetString(s)
+	}
+}
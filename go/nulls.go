@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// WithRejectNull rejects request bodies that send an explicit null for a
+// field that can't hold one, such as a string or int, which would
+// otherwise decode silently as the zero value. Pointers, interfaces, slices
+// and maps may still be null. Manager.RejectNull enables it for all
+// handlers.
+func WithRejectNull() HandlerOption {
+	return func(a *apiFunc) {
+		a.rejectNull = true
+	}
+}
+
+// checkNulls reports the null values in raw that t has nowhere to put.
+// Types that unmarshal themselves are left to do so.
+func checkNulls(raw []byte, t reflect.Type) error {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		// malformed bodies are left for the real decoder to report
+		return nil
+	}
+	var errs []FieldError
+	if doc == nil && !nullable(t) {
+		errs = append(errs, FieldError{Code: "null", Message: "must not be null"})
+	} else {
+		collectNulls(doc, t, "", &errs)
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Status: http.StatusBadRequest, Errors: errs}
+	}
+	return nil
+}
+
+func nullable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return true
+	}
+	return reflect.PointerTo(t).Implements(jsonUnmarshalerType)
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func collectNulls(doc interface{}, t reflect.Type, path string, errs *[]FieldError) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return
+	}
+	check := func(v interface{}, et reflect.Type, p string) {
+		if v == nil {
+			if !nullable(et) {
+				*errs = append(*errs, FieldError{Field: p, Code: "null", Message: "must not be null"})
+			}
+			return
+		}
+		collectNulls(v, et, p, errs)
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(obj) {
+			f, ok := fields.lookup(key)
+			if !ok {
+				continue
+			}
+			check(obj[key], f, joinPath(path, key))
+		}
+	case reflect.Slice, reflect.Array:
+		arr, ok := doc.([]interface{})
+		if !ok {
+			return
+		}
+		for i, v := range arr {
+			check(v, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, key := range sortedKeys(obj) {
+			check(obj[key], t.Elem(), joinPath(path, key))
+		}
+	}
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+type fieldTypes map[string]reflect.Type
+
+func (ft fieldTypes) lookup(key string) (reflect.Type, bool) {
+	if t, ok := ft[key]; ok {
+		return t, true
+	}
+	for name, t := range ft {
+		if strings.EqualFold(name, key) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// jsonFields maps the JSON keys of struct t to field types, with fields of
+// the outer struct taking precedence over promoted ones.
+func jsonFields(t reflect.Type) fieldTypes {
+	fields := fieldTypes{}
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	for _, et := range embedded {
+		for name, ft := range jsonFields(et) {
+			if _, ok := fields[name]; !ok {
+				fields[name] = ft
+			}
+		}
+	}
+	return fields
+}
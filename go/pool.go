@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+)
+
+var inputPools sync.Map // reflect.Type -> *sync.Pool
+
+// WithPooledInput reuses input values across requests through a sync.Pool
+// per input type, zeroing each one before it is decoded into. The value is
+// returned to the pool once the response is written, so handlers must not
+// keep the input, or a pointer input, beyond their own return: copy what
+// has to outlive the request. Slices and maps in a pooled input are
+// reallocated on each decode, so only the struct itself is reused.
+func WithPooledInput() HandlerOption {
+	return func(a *apiFunc) {
+		a.pooled = true
+	}
+}
+
+func inputPool(t reflect.Type) *sync.Pool {
+	if p, ok := inputPools.Load(t); ok {
+		return p.(*sync.Pool)
+	}
+	p, _ := inputPools.LoadOrStore(t, &sync.Pool{
+		New: func() interface{} { return reflect.New(t).Interface() },
+	})
+	return p.(*sync.Pool)
+}
+
+func (af *apiFunc) newInput() reflect.Value {
+	if !af.pooled {
+		return reflect.New(af.inputType)
+	}
+	v := reflect.ValueOf(inputPool(af.inputType).Get())
+	v.Elem().SetZero()
+	return v
+}
+
+func (af *apiFunc) releaseInput(v reflect.Value) {
+	if af.pooled && v.Kind() == reflect.Ptr && !v.IsNil() {
+		inputPool(af.inputType).Put(v.Interface())
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// ErrPreconditionFailed is returned by handlers, usually through
+// CheckIfMatch, when the client's If-Match does not match the current
+// version of the resource.
+var ErrPreconditionFailed = &Error{
+	Status:  http.StatusPreconditionFailed,
+	Code:    "precondition_failed",
+	Message: "precondition failed",
+}
+
+type ifMatchKey struct{}
+
+// IfMatchFromContext returns the request's If-Match header. Inputs can also
+// bind it with a `header:"If-Match"` tag.
+func IfMatchFromContext(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(ifMatchKey{}).(string)
+	return v, ok
+}
+
+// CheckIfMatch returns ErrPreconditionFailed unless the request has no
+// If-Match header or it matches etag, the resource's current entity tag,
+// by strong comparison. etag may be given with or without quotes.
+func CheckIfMatch(ctx context.Context, etag string) error {
+	header, ok := IfMatchFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, "W/") {
+		etag = `"` + etag + `"`
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || (tag == etag && !strings.HasPrefix(tag, "W/")) {
+			return nil
+		}
+	}
+	return ErrPreconditionFailed
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+const problemCT = "application/problem+json"
+
+// Problem is an RFC 7807 problem detail. Handlers return it as an error and
+// SendError writes it as application/problem+json. Extensions are merged
+// into the top-level object; they can't override the standard members.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]interface{}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	if p.Title != "" {
+		return p.Title
+	}
+	return http.StatusText(p.status())
+}
+
+func (p *Problem) status() int {
+	if p.Status == 0 {
+		return http.StatusInternalServerError
+	}
+	return p.Status
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	doc := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		doc[k] = v
+	}
+	typ := p.Type
+	if typ == "" {
+		typ = "about:blank"
+	}
+	doc["type"] = typ
+	title := p.Title
+	if title == "" {
+		title = http.StatusText(p.status())
+	}
+	doc["title"] = title
+	doc["status"] = p.status()
+	if p.Detail != "" {
+		doc["detail"] = p.Detail
+	} else {
+		delete(doc, "detail")
+	}
+	if p.Instance != "" {
+		doc["instance"] = p.Instance
+	} else {
+		delete(doc, "instance")
+	}
+	return json.Marshal(doc)
+}
+
+func (m *Manager) sendProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	p = m.ErrorMetadata.applyProblem(r, p)
+	var buf bytes.Buffer
+	encoder := m.Codec.NewEncoder(&buf)
+	if indent := m.indent(); indent != "" {
+		encoder.SetIndent("", indent)
+	}
+	if err := encoder.Encode(p); err != nil {
+		LoggerFromContext(r.Context()).Error("error encoding response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", problemCT)
+	w.WriteHeader(p.status())
+	_, _ = w.Write(buf.Bytes())
+}
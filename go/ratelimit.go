@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit is a limiter's verdict on one request, sent to clients in the
+// draft RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+type RateLimit struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+}
+
+type RateLimiter interface {
+	Take(r *http.Request) RateLimit
+}
+
+// WithRateLimit counts each request against l. Rejected requests get
+// Manager.OnRateLimited, or 429 with Retry-After when it is nil.
+func WithRateLimit(l RateLimiter) HandlerOption {
+	return func(a *apiFunc) {
+		a.rateLimiter = l
+	}
+}
+
+// rateLimit returns false when r was rejected and the response written.
+func (m *Manager) rateLimit(w http.ResponseWriter, r *http.Request, l RateLimiter) bool {
+	rl := l.Take(r)
+	reset := int((rl.Reset + time.Second - 1) / time.Second)
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(rl.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(max(rl.Remaining, 0)))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
+	if rl.Allowed {
+		return true
+	}
+	if m.OnRateLimited != nil {
+		m.OnRateLimited(w, r, rl)
+		return false
+	}
+	m.SendError(w, r, &Error{
+		Status:     http.StatusTooManyRequests,
+		Code:       "rate_limited",
+		Message:    "too many requests",
+		Retryable:  true,
+		RetryAfter: rl.Reset,
+	})
+	return false
+}
+
+// FixedWindowLimiter allows Limit requests per Window for each key, such
+// as a client IP or API key. All counters reset at the end of each window.
+type FixedWindowLimiter struct {
+	Limit  int
+	Window time.Duration
+	Key    func(r *http.Request) string
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+func NewFixedWindowLimiter(limit int, window time.Duration, key func(r *http.Request) string) *FixedWindowLimiter {
+	return &FixedWindowLimiter{Limit: limit, Window: window, Key: key}
+}
+
+func (l *FixedWindowLimiter) Take(r *http.Request) RateLimit {
+	now := time.Now()
+	key := l.Key(r)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts == nil || now.Sub(l.start) >= l.Window {
+		l.start = now.Truncate(l.Window)
+		l.counts = map[string]int{}
+	}
+	n := l.counts[key] + 1
+	if n <= l.Limit {
+		l.counts[key] = n
+	}
+	return RateLimit{
+		Allowed:   n <= l.Limit,
+		Limit:     l.Limit,
+		Remaining: l.Limit - min(n, l.Limit),
+		Reset:     l.start.Add(l.Window).Sub(now),
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverPanic turns a handler panic into a logged 500, or just the log when
+// the response has already started. http.ErrAbortHandler is re-raised so
+// net/http aborts the connection as intended.
+func (m *Manager) recoverPanic(w http.ResponseWriter, r *http.Request, rec *responseRecorder, v interface{}) {
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	LoggerFromContext(r.Context()).Error("handler panic", "panic", v, "stack", string(debug.Stack()))
+	m.notifyPanic(v, r)
+	if !rec.written {
+		m.SendError(w, r, &Error{Status: http.StatusInternalServerError, Message: "Internal Server Error"})
+	}
+}
+
+// notifyPanic calls OnPanic, containing any panic of its own.
+func (m *Manager) notifyPanic(v interface{}, r *http.Request) {
+	if m.OnPanic == nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			LoggerFromContext(r.Context()).Error("OnPanic panicked", "panic", p)
+		}
+	}()
+	m.OnPanic(v, r)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const redacted = "***"
+
+// WithResponseLog logs each successful output value for auditing. Struct
+// fields tagged `sensitive:"true"` are replaced with "***" in the logged
+// copy; the client still receives them.
+func WithResponseLog() HandlerOption {
+	return func(a *apiFunc) {
+		a.responseLog = true
+	}
+}
+
+// redact returns a copy of v for logging, shaped like its JSON encoding,
+// with sensitive fields masked. Values that marshal themselves are kept as
+// they are.
+func redact(v reflect.Value, visiting map[uintptr]bool) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return nil
+		}
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Ptr {
+			if visiting[v.Pointer()] {
+				return nil
+			}
+			visiting[v.Pointer()] = true
+			defer delete(visiting, v.Pointer())
+		}
+		return redact(v.Elem(), visiting)
+	case reflect.Struct:
+		out := map[string]interface{}{}
+		redactStruct(v, out, false, visiting)
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = redact(v.Index(i), visiting)
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[mapKeyString(iter.Key())] = redact(iter.Value(), visiting)
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// redactStruct adds the fields of v to out. Fields promoted from embedded
+// structs don't replace keys set by the outer struct.
+func redactStruct(v reflect.Value, out map[string]interface{}, embedded bool, visiting map[uintptr]bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if f.Anonymous && name == "" {
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				redactStruct(fv, out, true, visiting)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := out[name]; ok && embedded {
+			continue
+		}
+		if f.Tag.Get("sensitive") == "true" {
+			out[name] = redacted
+			continue
+		}
+		out[name] = redact(fv, visiting)
+	}
+}
+
+func mapKeyString(k reflect.Value) string {
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if b, err := tm.MarshalText(); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(k.Interface())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+type requestIDKey struct{}
+
+// UUIDRequestID generates random version 4 UUIDs.
+func UUIDRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Base62RequestID generates 22 character base62 IDs carrying about as much
+// randomness as a UUID.
+func Base62RequestID() string {
+	id := make([]byte, 0, 22)
+	var b [32]byte
+	for len(id) < cap(id) {
+		_, _ = rand.Read(b[:])
+		for _, c := range b {
+			// reject the top of the range to avoid modulo bias
+			if c < 248 && len(id) < cap(id) {
+				id = append(id, base62[c%62])
+			}
+		}
+	}
+	return string(id)
+}
+
+// withRequestID takes the request ID from the first of RequestIDHeaders
+// present on the request, generating one when none is, and echoes it in the
+// first header name on the response.
+func (m *Manager) withRequestID(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+	var id string
+	for _, h := range m.RequestIDHeaders {
+		if id = r.Header.Get(h); id != "" {
+			break
+		}
+	}
+	if id == "" && m.NewRequestID != nil {
+		id = m.NewRequestID()
+	}
+	if id == "" {
+		return ctx
+	}
+	if len(m.RequestIDHeaders) > 0 {
+		w.Header().Set(m.RequestIDHeaders[0], id)
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
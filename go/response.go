@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conditional lets a handler report when its resource last changed and defer
+// building the body until the client's cached copy is known to be stale.
+type Conditional struct {
+	LastModified time.Time
+	Body         func() (interface{}, error)
+}
+
+// Representer outputs serve several levels of detail from one handler. The
+// body is whatever Representation returns for the request's ?view=, or
+// Manager.DefaultView when it is absent.
+type Representer interface {
+	Representation(view string) interface{}
+}
+
+func (m *Manager) view(r *http.Request) string {
+	if view := r.URL.Query().Get("view"); view != "" {
+		return view
+	}
+	return m.DefaultView
+}
+
+func (m *Manager) writeOutput(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if rep, ok := v.(Representer); ok {
+		v = rep.Representation(m.view(r))
+	}
+	switch v := v.(type) {
+	case Conditional:
+		m.writeConditional(w, r, &v)
+	case *Conditional:
+		m.writeConditional(w, r, v)
+	case Result:
+		m.writeResult(w, r, &v)
+	case *Result:
+		m.writeResult(w, r, v)
+	case Partial:
+		m.writePartial(w, r, &v)
+	case *Partial:
+		m.writePartial(w, r, v)
+	case SetCookies:
+		m.writeCookies(w, r, &v)
+	case *SetCookies:
+		m.writeCookies(w, r, v)
+	case Preload:
+		m.writePreload(w, r, &v)
+	case *Preload:
+		m.writePreload(w, r, v)
+	case ContentLocation:
+		m.writeContentLocation(w, r, &v)
+	case *ContentLocation:
+		m.writeContentLocation(w, r, v)
+	case Created:
+		m.writeCreated(w, r, &v)
+	case *Created:
+		m.writeCreated(w, r, v)
+	case Accepted:
+		m.writeAccepted(w, r, &v)
+	case *Accepted:
+		m.writeAccepted(w, r, v)
+	case Stream:
+		m.writeStream(w, r, &v)
+	case *Stream:
+		m.writeStream(w, r, v)
+	case StreamArray:
+		m.writeStreamArray(w, r, &v)
+	case *StreamArray:
+		m.writeStreamArray(w, r, v)
+	case Multipart:
+		m.writeMultipart(w, r, &v)
+	case *Multipart:
+		m.writeMultipart(w, r, v)
+	case File:
+		m.writeFile(w, r, &v)
+	case *File:
+		m.writeFile(w, r, v)
+	default:
+		if m.writeJSONLines(w, r, v) {
+			return
+		}
+		if m.writeNegotiated(w, r, v) {
+			return
+		}
+		m.sendJSON(w, r, http.StatusOK, m.selectFields(r, v))
+	}
+}
+
+func (m *Manager) writeConditional(w http.ResponseWriter, r *http.Request, c *Conditional) {
+	if !c.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", c.LastModified.UTC().Format(http.TimeFormat))
+		if notModifiedSince(r, c.LastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	body, err := c.Body()
+	if err != nil {
+		w.Header().Del("Last-Modified")
+		m.SendError(w, r, unwrap(err))
+		return
+	}
+	m.writeOutput(w, r, body)
+}
+
+func notModifiedSince(r *http.Request, modtime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modtime.Truncate(time.Second).After(t)
+}
+
+// Result carries non-fatal warnings alongside a successful body. Each warning
+// is sent as an RFC 7234 Warning header with code 299.
+type Result struct {
+	Body     interface{}
+	Warnings []string
+}
+
+func (m *Manager) writeResult(w http.ResponseWriter, r *http.Request, res *Result) {
+	for _, warning := range res.Warnings {
+		w.Header().Add("Warning", "299 - "+strconv.Quote(warning))
+	}
+	m.writeOutput(w, r, res.Body)
+}
+
+// Partial is returned by handlers that ran out of time, typically on
+// ctx.Done(), and answer with what they gathered so far. Body is sent with
+// 206 Partial Content and each warning, or "partial results" when there are
+// none, as a Warning header.
+type Partial struct {
+	Body     interface{}
+	Warnings []string
+}
+
+func (m *Manager) writePartial(w http.ResponseWriter, r *http.Request, p *Partial) {
+	warnings := p.Warnings
+	if len(warnings) == 0 {
+		warnings = []string{"partial results"}
+	}
+	for _, warning := range warnings {
+		w.Header().Add("Warning", "299 - "+strconv.Quote(warning))
+	}
+	m.sendJSON(w, r, http.StatusPartialContent, m.selectFields(r, p.Body))
+}
+
+// SetCookies sets each cookie on the response before writing Body.
+type SetCookies struct {
+	Body    interface{}
+	Cookies []*http.Cookie
+}
+
+func (m *Manager) writeCookies(w http.ResponseWriter, r *http.Request, c *SetCookies) {
+	for _, cookie := range c.Cookies {
+		http.SetCookie(w, cookie)
+	}
+	m.writeOutput(w, r, c.Body)
+}
+
+// Preload adds Link rel=preload headers for resources the client will need
+// next. The links are dropped unless Manager.Preload is enabled.
+type Preload struct {
+	Body  interface{}
+	Links []PreloadLink
+}
+
+type PreloadLink struct {
+	URL string
+	As  string
+}
+
+func (m *Manager) writePreload(w http.ResponseWriter, r *http.Request, p *Preload) {
+	if m.Preload {
+		for _, link := range p.Links {
+			v := "<" + link.URL + ">; rel=preload"
+			if link.As != "" {
+				v += "; as=" + link.As
+			}
+			w.Header().Add("Link", v)
+		}
+	}
+	m.writeOutput(w, r, p.Body)
+}
+
+// ContentLocation names the canonical URL of Body in the Content-Location
+// header, for resources reached through an alias or a query lookup.
+type ContentLocation struct {
+	Body interface{}
+	URL  string
+}
+
+func (m *Manager) writeContentLocation(w http.ResponseWriter, r *http.Request, c *ContentLocation) {
+	if c.URL != "" {
+		w.Header().Set("Content-Location", c.URL)
+	}
+	m.writeOutput(w, r, c.Body)
+}
+
+// Created responds 201 with the new resource as the body and its URL in the
+// Location header.
+type Created struct {
+	Resource interface{}
+	Location string
+}
+
+func (m *Manager) writeCreated(w http.ResponseWriter, r *http.Request, c *Created) {
+	if c.Location != "" {
+		w.Header().Set("Location", c.Location)
+	}
+	m.sendJSON(w, r, http.StatusCreated, m.selectFields(r, c.Resource))
+}
+
+// Accepted acknowledges work that continues in the background. It responds
+// 202 with Location pointing at a resource reporting the operation's status.
+type Accepted struct {
+	Location string
+	Body     interface{}
+}
+
+func (m *Manager) writeAccepted(w http.ResponseWriter, r *http.Request, a *Accepted) {
+	if a.Location != "" {
+		w.Header().Set("Location", a.Location)
+	}
+	if a.Body == nil {
+		m.sendEmpty(w, http.StatusAccepted)
+		return
+	}
+	m.sendJSON(w, r, http.StatusAccepted, a.Body)
+}
+
+// Stream copies Body to the client, flushing as data arrives. Trailers
+// declares the trailer names up front; once Body is exhausted Trailer is
+// called with any read error to supply their values, letting a stream that
+// failed after the status was sent report it, e.g. X-Stream-Status: error.
+// Body is closed afterwards if it implements io.Closer.
+//
+// Streams are not content negotiated: ContentType is sent as is, and when
+// it is empty the type is sniffed from the first 512 bytes of Body with
+// http.DetectContentType.
+type Stream struct {
+	ContentType string
+	Body        io.Reader
+	Trailers    []string
+	Trailer     func(err error) http.Header
+}
+
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (fw flushWriter) Write(b []byte) (int, error) {
+	n, err := fw.w.Write(b)
+	if err == nil {
+		_ = fw.rc.Flush()
+	}
+	return n, err
+}
+
+func (m *Manager) writeStream(w http.ResponseWriter, r *http.Request, s *Stream) {
+	if c, ok := s.Body.(io.Closer); ok {
+		defer c.Close()
+	}
+	body := s.Body
+	if s.ContentType != "" {
+		w.Header().Set("Content-Type", s.ContentType)
+	} else {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(body, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			m.SendError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+		body = io.MultiReader(bytes.NewReader(head[:n]), body)
+	}
+	if len(s.Trailers) > 0 {
+		w.Header().Set("Trailer", strings.Join(s.Trailers, ", "))
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err := io.Copy(flushWriter{w: w, rc: http.NewResponseController(w)}, body)
+	if err != nil {
+		LoggerFromContext(r.Context()).Error("error streaming response", "error", err)
+	}
+	if s.Trailer != nil {
+		for k, vs := range s.Trailer(err) {
+			w.Header()[http.CanonicalHeaderKey(k)] = vs
+		}
+	}
+}
+
+var readCloserType = reflect.TypeOf((*io.ReadCloser)(nil)).Elem()
+
+// writeSized streams the body of a handler returning (io.ReadCloser, int64,
+// error), declaring its length unless it is -1, like a Stream otherwise.
+func (m *Manager) writeSized(w http.ResponseWriter, r *http.Request, body interface{}, length int64) {
+	rc, _ := body.(io.ReadCloser)
+	if rc == nil {
+		m.sendEmpty(w, m.EmptyStatus)
+		return
+	}
+	if length >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	m.writeStream(w, r, &Stream{Body: rc})
+}
+
+// StreamArray writes the values received from Items, a channel, as a JSON
+// array without buffering it, after sending Total as X-Total-Count. Err, if
+// set, is called once Items is closed; an error with no items sent yet gets
+// a normal error response, while a later one truncates the array.
+type StreamArray struct {
+	Total int
+	Items interface{}
+	Err   func() error
+}
+
+func (m *Manager) writeStreamArray(w http.ResponseWriter, r *http.Request, s *StreamArray) {
+	items := reflect.ValueOf(s.Items)
+	if items.Kind() != reflect.Chan || items.Type().ChanDir()&reflect.RecvDir == 0 {
+		m.SendError(w, r, fmt.Errorf("StreamArray.Items is %T, not a channel", s.Items))
+		return
+	}
+	fw := flushWriter{w: w, rc: http.NewResponseController(w)}
+	var buf bytes.Buffer
+	encoder := m.Codec.NewEncoder(&buf)
+	sent := 0
+	for {
+		v, ok := items.TryRecv()
+		if !ok && v.IsValid() {
+			break
+		}
+		if !v.IsValid() {
+			// nothing ready: get what was written so far to the client
+			if sent > 0 {
+				_ = fw.rc.Flush()
+			}
+			if v, ok = items.Recv(); !ok {
+				break
+			}
+		}
+		buf.Reset()
+		if sent == 0 {
+			buf.WriteByte('[')
+		} else {
+			buf.WriteByte(',')
+		}
+		if err := encoder.Encode(v.Interface()); err != nil {
+			LoggerFromContext(r.Context()).Error("error encoding response", "error", err)
+			drain(items)
+			if sent == 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		if sent == 0 {
+			w.Header().Set("Content-Type", jsonCT)
+			w.Header().Set("X-Total-Count", strconv.Itoa(s.Total))
+			w.WriteHeader(http.StatusOK)
+		}
+		sent++
+		if _, err := w.Write(bytes.TrimRight(buf.Bytes(), "\n")); err != nil {
+			drain(items)
+			return
+		}
+	}
+	var err error
+	if s.Err != nil {
+		err = s.Err()
+	}
+	if err != nil {
+		if sent == 0 {
+			m.SendError(w, r, unwrap(err))
+			return
+		}
+		LoggerFromContext(r.Context()).Error("error streaming response", "error", err)
+		return
+	}
+	if sent == 0 {
+		w.Header().Set("Content-Type", jsonCT)
+		w.Header().Set("X-Total-Count", strconv.Itoa(s.Total))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("[]"))
+		return
+	}
+	_, _ = fw.Write([]byte("]"))
+}
+
+// drain keeps receiving from ch until it is closed so its producer isn't
+// left blocked after the response is abandoned.
+func drain(ch reflect.Value) {
+	go func() {
+		for {
+			if _, ok := ch.Recv(); !ok {
+				return
+			}
+		}
+	}()
+}
+
+// File is served with http.ServeContent, which handles range requests,
+// conditional requests and content type detection. Either Path names a file
+// on disk or Content supplies the data, with Name and ModTime describing it.
+// Content is closed after serving if it implements io.Closer.
+type File struct {
+	Path    string
+	Content io.ReadSeeker
+	Name    string
+	ModTime time.Time
+}
+
+func (m *Manager) writeFile(w http.ResponseWriter, r *http.Request, file *File) {
+	content, name, modtime := file.Content, file.Name, file.ModTime
+	if file.Path != "" {
+		f, err := os.Open(file.Path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				err = &Error{Status: http.StatusNotFound, Message: "file not found"}
+			}
+			m.SendError(w, r, err)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			m.SendError(w, r, err)
+			return
+		}
+		content = f
+		if name == "" {
+			name = filepath.Base(file.Path)
+		}
+		if modtime.IsZero() {
+			modtime = fi.ModTime()
+		}
+	} else if content == nil {
+		m.SendError(w, r, errors.New("file has neither Path nor Content"))
+		return
+	} else if c, ok := content.(io.Closer); ok {
+		defer c.Close()
+	}
+	http.ServeContent(w, r, name, modtime, content)
+}
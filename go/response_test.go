@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestStreamContentType(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		accept      string
+		body        []byte
+		want        string
+	}{
+		{"explicit beats sniffing", "text/csv", "", pngHeader, "text/csv"},
+		{"explicit beats Accept json", "text/csv", "application/json", []byte("a,b\n"), "text/csv"},
+		{"explicit beats Accept csv", "image/png", "text/csv", pngHeader, "image/png"},
+		{"sniffed beats Accept json", "", "application/json", pngHeader, "image/png"},
+		{"sniffed beats Accept csv", "", "text/csv", []byte("<html></html>"), "text/html; charset=utf-8"},
+		{"short text", "", "", []byte("<html><body>hi</body></html>"), "text/html; charset=utf-8"},
+		{"short binary", "", "", pngHeader, "image/png"},
+		{"unknown binary", "", "", []byte{0x00, 0x01, 0x02, 0xff}, "application/octet-stream"},
+		{"longer than the sniffed prefix", "", "", append([]byte("%PDF-"), bytes.Repeat([]byte{'x'}, 2000)...), "application/pdf"},
+		{"empty", "", "", nil, "text/plain; charset=utf-8"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewManager(slog.Default())
+			m.Route("GET /file", func(ctx context.Context) (Stream, error) {
+				return Stream{ContentType: tc.contentType, Body: bytes.NewReader(tc.body)}, nil
+			})
+			var header []string
+			if tc.accept != "" {
+				header = []string{"Accept", tc.accept}
+			}
+			w := serve(m, "GET", "/file", "", header...)
+			if got := w.Header().Get("Content-Type"); got != tc.want {
+				t.Errorf("Content-Type = %q, want %q", got, tc.want)
+			}
+			if !bytes.Equal(w.Body.Bytes(), tc.body) {
+				t.Errorf("body = %q, want %q", w.Body.Bytes(), tc.body)
+			}
+		})
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestSizedResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		length     int64
+		wantLength string
+		body       []byte
+		wantType   string
+	}{
+		{"declared length", 4, "4", []byte("data"), "text/plain; charset=utf-8"},
+		{"unknown length", -1, "", pngHeader, "image/png"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := &closeRecorder{Reader: bytes.NewReader(tc.body)}
+			m := NewManager(slog.Default())
+			m.Route("GET /file", func(ctx context.Context) (io.ReadCloser, int64, error) {
+				return body, tc.length, nil
+			})
+			w := serve(m, "GET", "/file", "")
+			if got := w.Header().Get("Content-Length"); got != tc.wantLength {
+				t.Errorf("Content-Length = %q, want %q", got, tc.wantLength)
+			}
+			if got := w.Header().Get("Content-Type"); got != tc.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tc.wantType)
+			}
+			if !bytes.Equal(w.Body.Bytes(), tc.body) {
+				t.Errorf("body = %q, want %q", w.Body.Bytes(), tc.body)
+			}
+			if !body.closed {
+				t.Error("body was not closed")
+			}
+		})
+	}
+}
+
+func TestSizedResponseClosedOnError(t *testing.T) {
+	body := &closeRecorder{Reader: strings.NewReader("partial")}
+	m := NewManager(slog.Default())
+	m.Route("GET /file", func(ctx context.Context) (io.ReadCloser, int64, error) {
+		return body, 7, &Error{Status: 404, Message: "not found"}
+	})
+	w := serve(m, "GET", "/file", "")
+	if w.Code != 404 {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+	if !body.closed {
+		t.Error("body was not closed")
+	}
+}
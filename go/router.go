@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+type route struct {
+	methods []string
+	options http.Handler
+}
+
+func (rt *route) allow() string {
+	methods := append([]string{http.MethodOptions}, rt.methods...)
+	seen := map[string]bool{}
+	var allow []string
+	for _, method := range methods {
+		if !seen[method] {
+			seen[method] = true
+			allow = append(allow, method)
+		}
+		if method == http.MethodGet && !seen[http.MethodHead] {
+			seen[http.MethodHead] = true
+			allow = append(allow, http.MethodHead)
+		}
+	}
+	sort.Strings(allow)
+	return strings.Join(allow, ", ")
+}
+
+type routePatternKey struct{}
+
+// RoutePatternFromContext returns the pattern the request was routed by,
+// such as "GET /users/{id}", suitable as a low-cardinality metrics label.
+func RoutePatternFromContext(ctx context.Context) string {
+	pattern, _ := ctx.Value(routePatternKey{}).(string)
+	return pattern
+}
+
+// RouteInfo describes a route as it is registered.
+type RouteInfo struct {
+	Pattern string
+	Tags    []string
+}
+
+func (ri RouteInfo) HasTag(tag string) bool {
+	return slices.Contains(ri.Tags, tag)
+}
+
+// WithTags attaches tags to a route registered with Route, for UseIf.
+func WithTags(tags ...string) HandlerOption {
+	return func(a *apiFunc) {
+		a.tags = append(a.tags, tags...)
+	}
+}
+
+func routeTags(opts []HandlerOption) []string {
+	var af apiFunc
+	for _, opt := range opts {
+		opt(&af)
+	}
+	return af.tags
+}
+
+type conditionalMiddleware struct {
+	match func(RouteInfo) bool
+	mw    []func(http.Handler) http.Handler
+}
+
+// UseIf wraps the handlers of routes registered after it whose RouteInfo
+// satisfies match in mw. The first middleware is outermost, and middleware
+// from earlier calls wraps that of later ones.
+func (m *Manager) UseIf(match func(RouteInfo) bool, mw ...func(http.Handler) http.Handler) {
+	m.conditional = append(m.conditional, conditionalMiddleware{match: match, mw: mw})
+}
+
+func (m *Manager) wrapConditional(info RouteInfo, h http.Handler) http.Handler {
+	for i := len(m.conditional) - 1; i >= 0; i-- {
+		c := m.conditional[i]
+		if !c.match(info) {
+			continue
+		}
+		for j := len(c.mw) - 1; j >= 0; j-- {
+			h = c.mw[j](h)
+		}
+	}
+	return h
+}
+
+// Route binds the API function f to pattern, which uses http.ServeMux syntax
+// such as "GET /users/{id}".
+func (m *Manager) Route(pattern string, f interface{}, opts ...HandlerOption) {
+	m.handle(pattern, routeTags(opts), m.W(f, opts...))
+}
+
+// RouteMethods binds one API function to path for each of methods, as in
+// RouteMethods([]string{"PUT", "PATCH"}, "/users/{id}", f). Handlers that
+// behave differently per method take the *http.Request and check r.Method.
+func (m *Manager) RouteMethods(methods []string, path string, f interface{}, opts ...HandlerOption) {
+	h := m.W(f, opts...)
+	tags := routeTags(opts)
+	for _, method := range methods {
+		m.handle(method+" "+path, tags, h)
+	}
+}
+
+func (m *Manager) Handle(pattern string, h http.Handler) {
+	m.handle(pattern, nil, h)
+}
+
+func (m *Manager) handle(pattern string, tags []string, h http.Handler) {
+	h = m.wrapConditional(RouteInfo{Pattern: pattern, Tags: tags}, h)
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		m.mux.Handle(pattern, h)
+		return
+	}
+	// "/users/{id}" and "/users/{name}" are one path to ServeMux, and
+	// registering OPTIONS for both would conflict
+	key := wildcardShape(path)
+	rt := m.routes[key]
+	if rt == nil {
+		rt = &route{}
+		m.routes[key] = rt
+		m.mux.Handle(http.MethodOptions+" "+path, m.optionsHandler(rt))
+	}
+	if method == http.MethodOptions {
+		rt.options = h
+		return
+	}
+	rt.methods = append(rt.methods, method)
+	m.mux.Handle(pattern, h)
+}
+
+// wildcardShape replaces the names of a pattern's wildcards, keeping
+// whether each matches the remainder of the path.
+func wildcardShape(path string) string {
+	var b strings.Builder
+	for {
+		open := strings.IndexByte(path, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(path[open:], '}')
+		if end < 0 {
+			break
+		}
+		name := path[open+1 : open+end]
+		b.WriteString(path[:open])
+		switch {
+		case name == "$":
+			b.WriteString("{$}")
+		case strings.HasSuffix(name, "..."):
+			b.WriteString("{...}")
+		default:
+			b.WriteString("{}")
+		}
+		path = path[open+end+1:]
+	}
+	b.WriteString(path)
+	return b.String()
+}
+
+func (m *Manager) optionsHandler(rt *route) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rt.options != nil {
+			rt.options.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Allow", rt.allow())
+		if !m.AutoOptions {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Use wraps every request the Manager serves in mw. The first middleware
+// passed to the first call of Use is outermost.
+func (m *Manager) Use(mw ...func(http.Handler) http.Handler) {
+	m.middleware = append(m.middleware, mw...)
+	var h http.Handler = m.mux
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		h = m.middleware[i](h)
+	}
+	m.handler = h
+}
+
+// redirectSlash redirects requests that match no route to the same path
+// with its trailing slash added or removed, when that form does match.
+// GET and HEAD get 301; other methods get 308 so the method and body are
+// kept.
+func (m *Manager) redirectSlash(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Path == "/" {
+		return false
+	}
+	if _, pattern := m.mux.Handler(r); pattern != "" {
+		return false
+	}
+	// toggle the slash on the escaped path too, so that the target keeps
+	// escapes like %3F that would otherwise change its meaning
+	alt := *r.URL
+	escaped := alt.EscapedPath()
+	if strings.HasSuffix(alt.Path, "/") {
+		alt.Path = strings.TrimSuffix(alt.Path, "/")
+		escaped = strings.TrimSuffix(escaped, "/")
+	} else {
+		alt.Path += "/"
+		escaped += "/"
+	}
+	alt.RawPath = escaped
+	r2 := r.Clone(r.Context())
+	r2.URL = &alt
+	if _, pattern := m.mux.Handler(r2); pattern == "" {
+		return false
+	}
+	code := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	target := escaped
+	if alt.RawQuery != "" {
+		target += "?" + alt.RawQuery
+	}
+	http.Redirect(w, r, target, code)
+	return true
+}
+
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.RedirectTrailingSlash && m.redirectSlash(w, r) {
+		return
+	}
+	r = r.WithContext(withBag(r.Context()))
+	if m.handler != nil {
+		m.handler.ServeHTTP(w, r)
+		return
+	}
+	m.mux.ServeHTTP(w, r)
+}
+
+// Group registers routes under a shared path prefix, wrapping each handler
+// in the group's middleware. The first middleware is outermost, and nested
+// groups apply their parent's middleware first.
+type Group struct {
+	m      *Manager
+	prefix string
+	mw     []func(http.Handler) http.Handler
+}
+
+func (m *Manager) Group(prefix string, mw ...func(http.Handler) http.Handler) *Group {
+	return &Group{m: m, prefix: prefix, mw: mw}
+}
+
+func (g *Group) Group(prefix string, mw ...func(http.Handler) http.Handler) *Group {
+	return &Group{
+		m:      g.m,
+		prefix: g.prefix + prefix,
+		mw:     append(append([]func(http.Handler) http.Handler(nil), g.mw...), mw...),
+	}
+}
+
+func (g *Group) wrap(h http.Handler) http.Handler {
+	for i := len(g.mw) - 1; i >= 0; i-- {
+		h = g.mw[i](h)
+	}
+	return h
+}
+
+func (g *Group) W(f interface{}, opts ...HandlerOption) http.Handler {
+	return g.wrap(g.m.W(f, opts...))
+}
+
+func (g *Group) Route(pattern string, f interface{}, opts ...HandlerOption) {
+	g.handle(pattern, routeTags(opts), g.m.W(f, opts...))
+}
+
+func (g *Group) RouteMethods(methods []string, path string, f interface{}, opts ...HandlerOption) {
+	h := g.m.W(f, opts...)
+	tags := routeTags(opts)
+	for _, method := range methods {
+		g.handle(method+" "+path, tags, h)
+	}
+}
+
+func (g *Group) Handle(pattern string, h http.Handler) {
+	g.handle(pattern, nil, h)
+}
+
+func (g *Group) handle(pattern string, tags []string, h http.Handler) {
+	if method, path, ok := strings.Cut(pattern, " "); ok {
+		pattern = method + " " + g.prefix + path
+	} else {
+		pattern = g.prefix + pattern
+	}
+	g.m.handle(pattern, tags, g.wrap(h))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+)
+
+// Schema is a compiled JSON Schema. It is satisfied by the common schema
+// libraries, which validate an already decoded JSON document.
+type Schema interface {
+	Validate(doc interface{}) error
+}
+
+// WithSchema validates the raw request body against s before it is decoded
+// into the handler's input type.
+func WithSchema(s Schema) HandlerOption {
+	return func(a *apiFunc) {
+		a.schema = s
+	}
+}
+
+func (m *Manager) validateSchema(s Schema, raw []byte) error {
+	var doc interface{}
+	decoder := m.Codec.NewDecoder(bytes.NewReader(raw))
+	if err := decoder.Decode(&doc); err != nil {
+		return bodyError(err)
+	}
+	if err := s.Validate(doc); err != nil {
+		return &Error{Status: m.ValidationStatus, Message: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// ServiceRoutes lets a service passed to RegisterService choose patterns for
+// its methods, keyed by method name, such as "Search": "GET /users/search".
+// Paths are relative to the prefix.
+type ServiceRoutes interface {
+	Routes() map[string]string
+}
+
+var serviceMethods = map[string]string{
+	"Get":    http.MethodGet,
+	"Post":   http.MethodPost,
+	"Put":    http.MethodPut,
+	"Patch":  http.MethodPatch,
+	"Delete": http.MethodDelete,
+}
+
+// RegisterService routes each exported method of svc as an API function
+// under prefix. Unless Routes says otherwise, the route comes from the
+// method name: GetUser becomes "GET prefix/user" and DeleteUserByID
+// becomes "DELETE prefix/user-by-id". Nothing is registered when any
+// method can't be bound.
+func (m *Manager) RegisterService(prefix string, svc interface{}, opts ...HandlerOption) error {
+	v := reflect.ValueOf(svc)
+	var custom map[string]string
+	if sr, ok := svc.(ServiceRoutes); ok {
+		custom = sr.Routes()
+	}
+	patterns := map[string]http.Handler{}
+	var order []string
+	for i := 0; i < v.NumMethod(); i++ {
+		name := v.Type().Method(i).Name
+		if name == "Routes" && custom != nil {
+			continue
+		}
+		pattern, err := servicePattern(prefix, name, custom)
+		if err != nil {
+			return err
+		}
+		h, err := m.TryW(v.Method(i).Interface(), opts...)
+		if err != nil {
+			return fmt.Errorf("method %s: %w", name, err)
+		}
+		if _, ok := patterns[pattern]; ok {
+			return fmt.Errorf("method %s: duplicate route %q", name, pattern)
+		}
+		patterns[pattern] = h
+		order = append(order, pattern)
+	}
+	if len(order) == 0 {
+		return fmt.Errorf("%T has no exported methods", svc)
+	}
+	for _, pattern := range order {
+		m.handle(pattern, routeTags(opts), patterns[pattern])
+	}
+	return nil
+}
+
+func servicePattern(prefix, name string, custom map[string]string) (string, error) {
+	if pattern, ok := custom[name]; ok {
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			return "", fmt.Errorf("method %s: route %q has no HTTP method", name, pattern)
+		}
+		return method + " " + prefix + path, nil
+	}
+	for verb, method := range serviceMethods {
+		rest, ok := strings.CutPrefix(name, verb)
+		if !ok || (rest != "" && !unicode.IsUpper(rune(rest[0]))) {
+			continue
+		}
+		path := prefix
+		if rest != "" {
+			path += "/" + kebab(rest)
+		}
+		if path == "" {
+			path = "/"
+		}
+		return method + " " + path, nil
+	}
+	return "", fmt.Errorf("method %s: name must start with Get, Post, Put, Patch or Delete, or be listed in Routes", name)
+}
+
+// kebab turns UserByID into user-by-id.
+func kebab(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 &&
+			(unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteByte('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Signature verifies an HMAC of the raw request body, as sent by webhook
+// providers. Header holds the hex digest, optionally after Prefix such as
+// "sha256=". Secret looks up the key for the request.
+type Signature struct {
+	Header string
+	Prefix string
+	Hash   func() hash.Hash
+	Secret func(r *http.Request) ([]byte, error)
+}
+
+// WithSignature buffers the request body and rejects the request with 401
+// unless it carries a valid signature, before the body is decoded.
+func WithSignature(s Signature) HandlerOption {
+	return func(a *apiFunc) {
+		a.signature = &s
+	}
+}
+
+func (s *Signature) verify(r *http.Request) error {
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return bodyError(err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+	unauthorized := &Error{Status: http.StatusUnauthorized, Message: "invalid signature"}
+	sig, ok := strings.CutPrefix(r.Header.Get(s.Header), s.Prefix)
+	if !ok || sig == "" {
+		return unauthorized
+	}
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return unauthorized
+	}
+	secret, err := s.Secret(r)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(s.Hash, secret)
+	mac.Write(raw)
+	if !hmac.Equal(mac.Sum(nil), want) {
+		return unauthorized
+	}
+	return nil
+}
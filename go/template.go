@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"text/template"
+)
+
+type responseTemplate struct {
+	contentType string
+	tmpl        *template.Template
+}
+
+// WithTemplate renders the handler's output with tmpl for clients that
+// prefer contentType over JSON, e.g. "text/plain".
+func WithTemplate(contentType string, tmpl *template.Template) HandlerOption {
+	return func(a *apiFunc) {
+		a.template = &responseTemplate{contentType: contentType, tmpl: tmpl}
+	}
+}
+
+func (t *responseTemplate) wanted(r *http.Request) bool {
+	return negotiate(r, "application/json", t.contentType) == t.contentType
+}
+
+func (m *Manager) writeTemplate(w http.ResponseWriter, r *http.Request, t *responseTemplate, v interface{}) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, v); err != nil {
+		m.SendError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", t.contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
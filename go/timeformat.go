@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// timeFields maps the JSON keys of an input struct to fields tagged
+// `time_format:"unix"` or `time_format:"unixmilli"`, or to nested structs
+// containing such fields. encoding/json can't be told about these through
+// tags, so matching numbers are rewritten to RFC 3339 strings before the
+// body is decoded. Strings are still accepted. Fields inside slices and maps
+// are not rewritten.
+type timeFields map[string]timeField
+
+type timeField struct {
+	unit   string
+	nested timeFields
+}
+
+func timeFieldsFor(t reflect.Type, visiting map[reflect.Type]bool) (timeFields, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType || visiting[t] {
+		return nil, nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	var fields timeFields
+	add := func(name string, f timeField) {
+		if fields == nil {
+			fields = timeFields{}
+		}
+		fields[name] = f
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			sub, err := timeFieldsFor(f.Type, visiting)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range sub {
+				if _, ok := fields[k]; !ok {
+					add(k, v)
+				}
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if unit, ok := f.Tag.Lookup("time_format"); ok {
+			if unit != "unix" && unit != "unixmilli" {
+				return nil, fmt.Errorf("field %s: unknown time_format %q", f.Name, unit)
+			}
+			if ft := f.Type; ft != timeType && (ft.Kind() != reflect.Ptr || ft.Elem() != timeType) {
+				return nil, fmt.Errorf("field %s: time_format requires a time.Time", f.Name)
+			}
+			add(name, timeField{unit: unit})
+			continue
+		}
+		nested, err := timeFieldsFor(f.Type, visiting)
+		if err != nil {
+			return nil, err
+		}
+		if nested != nil {
+			add(name, timeField{nested: nested})
+		}
+	}
+	return fields, nil
+}
+
+func (tf timeFields) lookup(key string) (timeField, bool) {
+	if f, ok := tf[key]; ok {
+		return f, true
+	}
+	for name, f := range tf {
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return timeField{}, false
+}
+
+// convertEpochs rewrites epoch numbers in raw for the fields in tf. Bodies
+// that aren't objects are returned unchanged for the decoder to reject.
+func convertEpochs(raw []byte, tf timeFields) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil || obj == nil {
+		return raw, nil
+	}
+	changed := false
+	for key, v := range obj {
+		f, ok := tf.lookup(key)
+		if !ok {
+			continue
+		}
+		var out []byte
+		var err error
+		if f.unit != "" {
+			out, err = epochToRFC3339(v, f.unit)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", key, err)
+			}
+		} else {
+			out, err = convertEpochs(v, f.nested)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !bytes.Equal(out, v) {
+			obj[key] = out
+			changed = true
+		}
+	}
+	if !changed {
+		return raw, nil
+	}
+	return json.Marshal(obj)
+}
+
+func epochToRFC3339(v json.RawMessage, unit string) ([]byte, error) {
+	s := string(bytes.TrimSpace(v))
+	if s == "" || (s[0] != '-' && (s[0] < '0' || s[0] > '9')) {
+		return v, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s timestamp %s", unit, s)
+	}
+	t := time.Unix(n, 0)
+	if unit == "unixmilli" {
+		t = time.UnixMilli(n)
+	}
+	return json.Marshal(t.UTC())
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	errReadTimeout = &Error{
+		Status:  http.StatusRequestTimeout,
+		Code:    "read_timeout",
+		Message: "timed out reading request body",
+	}
+	errProcessTimeout = &Error{
+		Status:    http.StatusGatewayTimeout,
+		Code:      "processing_timeout",
+		Message:   "timed out processing request",
+		Retryable: true,
+	}
+)
+
+// WithTimeouts bounds every read of the body, by signature checks and
+// idempotency as well as decoding, by read, answering 408 when it runs out,
+// and the handler call by process, answering 504 when the handler returns a
+// context.DeadlineExceeded error after its context expired. Handlers must
+// watch ctx.Done() for the processing deadline to take effect. Zero leaves a
+// phase unbounded. Streamed inputs are read by the handler, so they can't
+// have a read timeout.
+func WithTimeouts(read, process time.Duration) HandlerOption {
+	return func(a *apiFunc) {
+		a.readTimeout = read
+		a.processTimeout = process
+	}
+}
+
+// deadlineBody fails reads once deadline passes. It also sets the
+// connection read deadline, when the server supports it, so a read stalled
+// on a slow client is interrupted rather than noticed afterwards.
+type deadlineBody struct {
+	io.ReadCloser
+	deadline time.Time
+	expired  bool
+}
+
+func withReadDeadline(w http.ResponseWriter, r *http.Request, timeout time.Duration) (*deadlineBody, func()) {
+	body := &deadlineBody{ReadCloser: r.Body, deadline: time.Now().Add(timeout)}
+	r.Body = body
+	rc := http.NewResponseController(w)
+	if rc.SetReadDeadline(body.deadline) != nil {
+		return body, func() {}
+	}
+	// an expired deadline stays, or the server would block draining the
+	// rest of the body before closing the connection
+	return body, func() {
+		if !body.expired {
+			_ = rc.SetReadDeadline(time.Time{})
+		}
+	}
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	if time.Now().After(b.deadline) {
+		b.expired = true
+		return 0, os.ErrDeadlineExceeded
+	}
+	n, err := b.ReadCloser.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		b.expired = true
+	}
+	return n, err
+}
+
+// readError replaces err with errReadTimeout when body's deadline expired
+// while err was being produced.
+func readError(w http.ResponseWriter, body *deadlineBody, err error) error {
+	if body == nil || !body.expired {
+		return err
+	}
+	w.Header().Set("Connection", "close")
+	return errReadTimeout
+}
+
+// processTimedOut reports whether err came from the processing deadline
+// rather than from a deadline the handler set itself or the caller's.
+func processTimedOut(ctx context.Context, err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) && errors.Is(context.Cause(ctx), errProcessTimeout)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+type startKey struct{}
+
+// RequestStartFromContext returns when the harness began handling the request.
+func RequestStartFromContext(ctx context.Context) (time.Time, bool) {
+	start, ok := ctx.Value(startKey{}).(time.Time)
+	return start, ok
+}
+
+// RemainingTime reports how long is left before the context deadline. It
+// returns false when the context has no deadline.
+func RemainingTime(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// requestTrace records how long each phase of a sampled request took. A nil
+// *requestTrace records nothing, so unsampled requests pay only for the
+// sampling decision.
+type requestTrace struct {
+	phases []tracePhase
+}
+
+type tracePhase struct {
+	name     string
+	duration time.Duration
+}
+
+func (m *Manager) startTrace() *requestTrace {
+	if m.TraceSampleRate <= 0 || rand.Float64() >= m.TraceSampleRate {
+		return nil
+	}
+	return &requestTrace{}
+}
+
+// phase starts timing name and returns the func that ends it.
+func (t *requestTrace) phase(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		t.phases = append(t.phases, tracePhase{name: name, duration: time.Since(start)})
+	}
+}
+
+func (m *Manager) logTrace(r *http.Request, t *requestTrace, rec *responseRecorder, elapsed time.Duration, reqBytes int64) {
+	if t == nil {
+		return
+	}
+	args := []interface{}{
+		"method", r.Method,
+		"route", RoutePatternFromContext(r.Context()),
+		"status", rec.status,
+		"request_bytes", reqBytes,
+		"response_bytes", rec.bytes,
+		"total", elapsed,
+	}
+	for _, p := range t.phases {
+		args = append(args, p.name, p.duration)
+	}
+	LoggerFromContext(r.Context()).Info("trace", args...)
+}
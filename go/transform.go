@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// WithBodyTransform runs f over the whole request body before it is
+// decoded, letting a handler accept input its decoder is too strict for.
+// An error from f is sent as 400.
+func WithBodyTransform(f func([]byte) ([]byte, error)) HandlerOption {
+	return func(a *apiFunc) {
+		a.transform = f
+	}
+}
+
+func (af *apiFunc) transformBody(r *http.Request) error {
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	raw, err = af.transform(raw)
+	if err != nil {
+		return &Error{Status: http.StatusBadRequest, Message: "invalid request body: " + err.Error()}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+	return nil
+}
+
+// JSONC is a body transform for JSON with comments: it removes // and /* */
+// comments and commas before a closing bracket or brace, leaving strings
+// untouched.
+func JSONC(b []byte) ([]byte, error) {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == '"':
+			j := i + 1
+			for ; j < len(b) && b[j] != '"'; j++ {
+				if b[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(b) {
+				return nil, errors.New("unterminated string")
+			}
+			out = append(out, b[i:j+1]...)
+			i = j
+		case c == '/' && i+1 < len(b) && b[i+1] == '/':
+			for i < len(b) && b[i] != '\n' {
+				i++
+			}
+			if i < len(b) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(b) && b[i+1] == '*':
+			end := bytes.Index(b[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			out = append(out, ' ')
+			i += end + 3
+		case c == ']' || c == '}':
+			// drop a trailing comma, keeping the whitespace after it
+			k := len(out) - 1
+			for k >= 0 && isJSONSpace(out[k]) {
+				k--
+			}
+			if k >= 0 && out[k] == ',' {
+				out = append(out[:k], out[k+1:]...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
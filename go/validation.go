@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// validator is implemented by inputs that check themselves after decoding.
+type validator interface {
+	Validate() error
+}
+
+var validatorType = reflect.TypeOf((*validator)(nil)).Elem()
+
+// FieldError describes one invalid field. Errors from binding also carry a
+// Code, "required" or "invalid", and the Source the value came from:
+// query, path, header or cookie.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError reports every invalid field of an input at once. Field
+// paths use dots for struct fields and map keys and [i] for indices. It is
+// sent with Status, or Manager.ValidationStatus when that is zero.
+type ValidationError struct {
+	Status int
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		if fe.Field == "" {
+			msgs[i] = fe.Message
+		} else {
+			msgs[i] = fe.Field + ": " + fe.Message
+		}
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+func (m *Manager) validationError(err error) error {
+	switch e := unwrap(err).(type) {
+	case *Error:
+		return e
+	case *ValidationError:
+		return e
+	}
+	return &Error{Status: m.ValidationStatus, Message: err.Error()}
+}
+
+// validates reports whether values of t, or the elements of a map or slice
+// type t, implement validator, looking through pointers.
+func validates(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(validatorType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Ptr:
+		return validates(t.Elem())
+	}
+	return false
+}
+
+// validateValue runs Validate on the value ptr points to and, for maps and
+// slices, on each element, aggregating element failures by key or index.
+// Pointer elements are followed and nil ones skipped.
+func validateValue(ptr reflect.Value) error {
+	if v, ok := ptr.Interface().(validator); ok {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	v := ptr.Elem()
+	if !validates(v.Type()) {
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		// nil elements have nothing to validate
+		if v.IsNil() {
+			return nil
+		}
+		return validateValue(v)
+	}
+	var errs []FieldError
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			errs = appendFieldErrors(errs, fmt.Sprintf("[%d]", i), validateValue(v.Index(i).Addr()))
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		for _, k := range keys {
+			elem := reflect.New(v.Type().Elem())
+			elem.Elem().Set(v.MapIndex(k))
+			errs = appendFieldErrors(errs, fmt.Sprint(k), validateValue(elem))
+			v.SetMapIndex(k, elem.Elem())
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func appendFieldErrors(errs []FieldError, prefix string, err error) []FieldError {
+	if err == nil {
+		return errs
+	}
+	verr, ok := unwrap(err).(*ValidationError)
+	if !ok {
+		return append(errs, FieldError{Field: prefix, Message: err.Error()})
+	}
+	for _, fe := range verr.Errors {
+		switch {
+		case fe.Field == "":
+			fe.Field = prefix
+		case strings.HasPrefix(fe.Field, "["):
+			fe.Field = prefix + fe.Field
+		default:
+			fe.Field = prefix + "." + fe.Field
+		}
+		errs = append(errs, fe)
+	}
+	return errs
+}
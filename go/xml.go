@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// DecodeXML fills v from an XML body using encoding/xml and its struct tags.
+// It is not registered by default; see RegisterDecoder.
+// encoding/xml has no equivalent of DisallowUnknownFields, so after decoding
+// the direct children of the root element are checked against the fields of
+// v; unknown elements deeper in the document and unknown attributes are
+// still ignored, as are all checks when v has an ",any" or ",innerxml"
+// field. Unlike the JSON path, MaxDepth, UseNumber and schemas do not apply.
+func DecodeXML(r io.Reader, v interface{}) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return io.EOF
+	}
+	if err := xml.Unmarshal(raw, v); err != nil {
+		return err
+	}
+	t := reflect.TypeOf(v).Elem()
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	known, any := xmlElementNames(t)
+	if any {
+		return nil
+	}
+	return checkXMLChildren(raw, known)
+}
+
+func xmlElementNames(t reflect.Type) (map[string]bool, bool) {
+	known := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Name == "XMLName" {
+			continue
+		}
+		tag := f.Tag.Get("xml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		switch {
+		case strings.Contains(opts, "any"), strings.Contains(opts, "innerxml"):
+			return nil, true
+		case strings.Contains(opts, "attr"), strings.Contains(opts, "chardata"),
+			strings.Contains(opts, "cdata"), strings.Contains(opts, "comment"):
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				sub, any := xmlElementNames(ft)
+				if any {
+					return nil, true
+				}
+				for k := range sub {
+					known[k] = true
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		// "a>b" nests the field under element a
+		name, _, _ = strings.Cut(name, ">")
+		known[name] = true
+	}
+	return known, false
+}
+
+func checkXMLChildren(raw []byte, known map[string]bool) error {
+	decoder := xml.NewDecoder(bytes.NewReader(raw))
+	depth := 0
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 && !known[tok.Name.Local] {
+				return fmt.Errorf("xml: unknown element %q", tok.Name.Local)
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}